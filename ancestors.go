@@ -0,0 +1,210 @@
+package lolhtml
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// Ancestor is a snapshot of an element enclosing the element passed to a handler, see
+// Element.Ancestors.
+type Ancestor struct {
+	TagName string
+	ID      string
+	Class   string
+}
+
+// openElement is an element of the stack of open elements of a Writer.
+type openElement struct {
+	Ancestor
+	children int            // number of child elements seen so far
+	types    map[string]int // number of child elements seen so far by tag name
+	siblings []Ancestor     // child elements seen so far, only kept for extended selectors
+	userData unsafe.Pointer // the element saved for its end tag handler, see trackAncestors
+}
+
+// position is the position of an element among its siblings, see extendedSelector.
+type position struct {
+	index     int        // 1-based index among the child elements of its parent
+	typeIndex int        // 1-based index among the child elements of its parent with the same tag name
+	siblings  []Ancestor // previous siblings, from the first one
+}
+
+// ancestorsSelector matches all elements, to keep track of the open elements.
+const ancestorsSelector = "*"
+
+// closeRule lists the open elements implicitly closed by a start tag, and the elements that
+// stop the search for them.
+type closeRule struct {
+	closes []string
+	scope  []string
+}
+
+// implicitClose maps a tag name to the open elements its start tag implicitly closes, as in
+// "<li>1<li>2" or "<p>1<div>2". This is a simplified subset of the rules of the HTML tree
+// construction.
+var implicitClose = map[string]closeRule{
+	"li":       {[]string{"li"}, []string{"ul", "ol", "menu", "table", "html"}},
+	"dt":       {[]string{"dt", "dd"}, []string{"dl", "table", "html"}},
+	"dd":       {[]string{"dt", "dd"}, []string{"dl", "table", "html"}},
+	"option":   {[]string{"option"}, []string{"select", "datalist", "html"}},
+	"optgroup": {[]string{"option", "optgroup"}, []string{"select", "html"}},
+	"tr":       {[]string{"tr", "td", "th"}, []string{"table", "thead", "tbody", "tfoot", "html"}},
+	"td":       {[]string{"td", "th"}, []string{"tr", "table", "html"}},
+	"th":       {[]string{"td", "th"}, []string{"tr", "table", "html"}},
+	"thead":    {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table", "html"}},
+	"tbody":    {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table", "html"}},
+	"tfoot":    {[]string{"thead", "tbody", "tfoot", "tr", "td", "th"}, []string{"table", "html"}},
+	"body":     {[]string{"head"}, []string{"html"}},
+}
+
+func init() {
+	// start tags of block elements close an open <p>
+	closeP := closeRule{[]string{"p"}, []string{"button", "table", "html"}}
+	for _, name := range []string{
+		"address", "article", "aside", "blockquote", "details", "dialog", "div", "dl", "fieldset",
+		"figcaption", "figure", "footer", "form", "h1", "h2", "h3", "h4", "h5", "h6", "header", "hgroup",
+		"hr", "listing", "main", "menu", "nav", "ol", "p", "plaintext", "pre", "section", "table", "ul",
+	} {
+		implicitClose[name] = closeP
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// trackAncestors is registered by Compile for ancestorsSelector, before the user's handlers, when
+// Config.TrackAncestors is set or extended selectors are used. lol_html does not keep the stack of open elements, so it is kept
+// on the Writer: elements are pushed at their start tag and popped at their end tag, or when the
+// start tag of another element implicitly closes them. Elements without an end tag, e.g. void
+// elements like <br>, are never pushed, but they are counted as the children of the innermost open
+// element, for the structural pseudo-classes of extended selectors.
+//
+// Each open element is saved as the user data of its end tag handler, and freed when the handler
+// is called. An element closed before its end tag only forgets its value, as lol_html may still
+// call its handler later with the same user data, and the user data is freed with the rewriter.
+func trackAncestors(e *Element) RewriterDirective {
+	w := currentWriter()
+	if w == nil {
+		return Continue
+	}
+	name := strings.ToLower(e.TagName())
+	if rule, ok := implicitClose[name]; ok && e.NamespaceURI() == htmlNamespace {
+		w.closeImplicitly(rule.closes, rule.scope)
+	}
+	w.depth = len(w.openElements)
+
+	el := &openElement{Ancestor: Ancestor{TagName: name}}
+	el.ID, _ = e.Lookup("id")
+	el.Class, _ = e.Lookup("class")
+	w.addChild(el.Ancestor)
+	el.userData = savePointer(el)
+	if err := e.addEndTagHandler(el.userData); err != nil {
+		unrefPointer(el.userData)
+		return Continue
+	}
+	w.openElements = append(w.openElements, el)
+	return Continue
+}
+
+// addChild counts a as a child of the innermost open element, and records its position.
+func (w *Writer) addChild(a Ancestor) {
+	parent := &w.root
+	if w.depth > 0 {
+		parent = w.openElements[w.depth-1]
+	}
+	if parent.types == nil {
+		parent.types = make(map[string]int)
+	}
+	parent.children++
+	parent.types[a.TagName]++
+	w.position = position{
+		index:     parent.children,
+		typeIndex: parent.types[a.TagName],
+		siblings:  parent.siblings[:len(parent.siblings):len(parent.siblings)],
+	}
+	if w.t.siblings {
+		parent.siblings = append(parent.siblings, a)
+	}
+}
+
+// closeImplicitly pops the open elements down to the last one named in closes, unless an element
+// named in scope is found first.
+func (w *Writer) closeImplicitly(closes, scope []string) {
+	for i := len(w.openElements) - 1; i >= 0; i-- {
+		name := w.openElements[i].TagName
+		if containsString(closes, name) {
+			w.popElements(i)
+			return
+		}
+		if containsString(scope, name) {
+			return
+		}
+	}
+}
+
+// closeElement is called at the end tag of el, and pops the open elements down to el, closing the
+// elements left open inside it, as in "<ul><li>1</ul>".
+func (w *Writer) closeElement(el *openElement) {
+	for i := len(w.openElements) - 1; i >= 0; i-- {
+		if w.openElements[i] == el {
+			w.openElements[i] = nil
+			w.popElements(i)
+			break
+		}
+	}
+	unrefPointer(el.userData)
+}
+
+// popElements pops the open elements from index i, which are closed before their end tag.
+func (w *Writer) popElements(i int) {
+	for j, el := range w.openElements[i:] {
+		if el != nil {
+			forgetPointer(el.userData)
+			w.userData = append(w.userData, el.userData)
+		}
+		w.openElements[i+j] = nil
+	}
+	w.openElements = w.openElements[:i]
+}
+
+// Depth returns the number of elements enclosing the element, e.g. 0 for <html>.
+// Config.TrackAncestors must be set, otherwise Depth always returns 0.
+// It is only valid to call Depth inside element handlers.
+func (e *Element) Depth() int {
+	if w := currentWriter(); w != nil {
+		return w.depth
+	}
+	return 0
+}
+
+// Ancestors returns a snapshot of the elements enclosing the element, from the outermost to the
+// parent of the element. Config.TrackAncestors must be set, otherwise Ancestors always returns nil.
+// It is only valid to call Ancestors inside element handlers.
+func (e *Element) Ancestors() []Ancestor {
+	w := currentWriter()
+	if w == nil || w.depth == 0 {
+		return nil
+	}
+	ancestors := make([]Ancestor, w.depth)
+	for i, el := range w.openElements[:w.depth] {
+		ancestors[i] = el.Ancestor
+	}
+	return ancestors
+}
+
+// Parent returns a snapshot of the parent of the element, and whether there is one.
+// Config.TrackAncestors must be set, otherwise Parent always returns false.
+// It is only valid to call Parent inside element handlers.
+func (e *Element) Parent() (Ancestor, bool) {
+	w := currentWriter()
+	if w == nil || w.depth == 0 {
+		return Ancestor{}, false
+	}
+	return w.openElements[w.depth-1].Ancestor, true
+}
@@ -0,0 +1,120 @@
+package lolhtml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestElement_Ancestors(t *testing.T) {
+	paths := map[string]string{}
+	_, err := lolhtml.RewriteString(
+		`<nav id="top"><ul class="menu"><li><a id="1"></a><li><br><a id="2"></a></ul></nav>`+
+			`<p>text<div><a id="3"></a></div><a id="4"></a>`,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "a",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						id, _ := e.Lookup("id")
+						var names []string
+						for _, a := range e.Ancestors() {
+							names = append(names, a.TagName+"#"+a.ID+"."+a.Class)
+						}
+						if depth := e.Depth(); depth != len(names) {
+							t.Errorf("got depth %d for %v", depth, names)
+						}
+						parent, ok := e.Parent()
+						if ok != (len(names) > 0) || ok && parent.TagName+"#"+parent.ID+"."+parent.Class != names[len(names)-1] {
+							t.Errorf("got parent %+v, %v for %v", parent, ok, names)
+						}
+						paths[id] = strings.Join(names, " ")
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+		lolhtml.Config{
+			Encoding: "utf-8",
+			Memory: &lolhtml.MemorySettings{
+				PreallocatedParsingBufferSize: 1024,
+				MaxAllowedMemoryUsage:         1<<63 - 1,
+			},
+			Strict:         true,
+			TrackAncestors: true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantedPaths := map[string]string{
+		"1": "nav#top. ul#.menu li#.",
+		"2": "nav#top. ul#.menu li#.",
+		"3": "div#.",
+		"4": "",
+	}
+	for id, wantPath := range wantedPaths {
+		if path, ok := paths[id]; !ok || path != wantPath {
+			t.Errorf("got %q for #%s; want %q", path, id, wantPath)
+		}
+	}
+}
+
+func TestElement_AncestorsDisabled(t *testing.T) {
+	_, err := lolhtml.RewriteString(
+		"<div><a></a></div>",
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "a",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if depth, ancestors := e.Depth(), e.Ancestors(); depth != 0 || ancestors != nil {
+							t.Errorf("got %d, %v", depth, ancestors)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestElement_AncestorsReleased checks that the open elements are released at their end tag, or
+// when they are closed implicitly, rather than when the Writer is closed.
+func TestElement_AncestorsReleased(t *testing.T) {
+	w, err := lolhtml.NewWriter(
+		nil,
+		nil,
+		lolhtml.Config{
+			Encoding: "utf-8",
+			Memory: &lolhtml.MemorySettings{
+				PreallocatedParsingBufferSize: 1024,
+				MaxAllowedMemoryUsage:         1<<63 - 1,
+			},
+			Strict:         true,
+			TrackAncestors: true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := lolhtml.SavedPointers()
+	for i := 0; i < 1000; i++ {
+		if _, err = w.WriteString("<div><span></span></div><ul><li>1<li>2</ul><p>3"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := lolhtml.SavedPointers() - saved; n > 1 {
+		t.Errorf("got %d more saved values after writing; want at most 1", n)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if n := lolhtml.SavedPointers(); n >= saved {
+		t.Errorf("got %d saved values after closing; want less than %d", n, saved)
+	}
+}
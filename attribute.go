@@ -8,9 +8,17 @@ import "C"
 
 // AttributeIterator can be used to iterate over all attributes of an element. The only way to
 // get an AttributeIterator is by calling AttributeIterator() on an Element. Note the "range" syntax is not
-// applicable here, use AttributeIterator.Next() instead.
+// applicable here, use AttributeIterator.Next() instead, or Element.RangeAttributes which frees the
+// iterator itself.
 type AttributeIterator C.lol_html_attributes_iterator_t
 
+// AttributePair is the name and value of an attribute, as a plain Go value that remains valid
+// after the handler returns. See Element.Attributes.
+type AttributePair struct {
+	Name  string
+	Value string
+}
+
 // Attribute represents an HTML element attribute. Obtained by calling Next() on an AttributeIterator.
 type Attribute C.lol_html_attribute_t
 
@@ -16,12 +16,14 @@ const dataDir = "testdata"
 
 const ChunkSize = 1024
 
-func BenchmarkNewWriter(b *testing.B) {
-	benchmarks := []struct {
-		category string
-		name     string
-		handlers *lolhtml.Handlers
-	}{
+type benchmark struct {
+	category string
+	name     string
+	handlers *lolhtml.Handlers
+}
+
+func newBenchmarks() []benchmark {
+	return []benchmark{
 		{
 			"Parsing",
 			"TagScanner",
@@ -60,16 +62,12 @@ func BenchmarkNewWriter(b *testing.B) {
 				ElementContentHandler: []lolhtml.ElementContentHandler{
 					{
 						Selector: "body",
-						ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
-							err := e.SetTagName("body1")
-							if err != nil {
-								b.Fatal(err)
+						// an error fails the benchmark through Write
+						ElementHandlerE: func(e *lolhtml.Element) error {
+							if err := e.SetTagName("body1"); err != nil {
+								return err
 							}
-							err = e.InsertAfterEndTagAsText("test")
-							if err != nil {
-								b.Fatal(err)
-							}
-							return lolhtml.Continue
+							return e.InsertAfterEndTagAsText("test")
 						},
 					},
 				},
@@ -82,12 +80,8 @@ func BenchmarkNewWriter(b *testing.B) {
 				ElementContentHandler: []lolhtml.ElementContentHandler{
 					{
 						Selector: "ul",
-						ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
-							err := e.SetInnerContentAsText("")
-							if err != nil {
-								b.Fatal(err)
-							}
-							return lolhtml.Continue
+						ElementHandlerE: func(e *lolhtml.Element) error {
+							return e.SetInnerContentAsText("")
 						},
 					},
 				},
@@ -200,7 +194,18 @@ func BenchmarkNewWriter(b *testing.B) {
 			},
 		},
 	}
+}
 
+// runBenchmarks runs all benchmarks over all data files. For each benchmark, setup is called once
+// outside of the timed loop, and returns functions to get a Writer and to finish with it.
+func runBenchmarks(
+	b *testing.B,
+	setup func(b *testing.B, handlers *lolhtml.Handlers) (
+		get func() (*lolhtml.Writer, error),
+		done func(*lolhtml.Writer) error,
+		cleanup func(),
+	),
+) {
 	files, err := ioutil.ReadDir(dataDir)
 	if err != nil {
 		b.Fatal("benchmark data files not found", err)
@@ -212,14 +217,16 @@ func BenchmarkNewWriter(b *testing.B) {
 			b.Fatal("cannot read benchmark data files", err)
 		}
 
-		for _, bm := range benchmarks {
+		for _, bm := range newBenchmarks() {
 			b.Run(fmt.Sprintf("%s-%s-%s", bm.category, bm.name, file.Name()), func(b *testing.B) {
+				get, done, cleanup := setup(b, bm.handlers)
+				defer cleanup()
 				b.SetBytes(int64(len(data)))
 				b.ReportAllocs()
 				runtime.GC()
 				b.ResetTimer()
 				for i := 0; i < b.N; i++ {
-					w, err := lolhtml.NewWriter(nil, bm.handlers)
+					w, err := get()
 					if err != nil {
 						b.Fatal(err)
 					}
@@ -231,7 +238,7 @@ func BenchmarkNewWriter(b *testing.B) {
 						b.Fatal(err)
 					}
 
-					err = w.Close()
+					err = done(w)
 					if err != nil {
 						b.Fatal(err)
 					}
@@ -240,3 +247,59 @@ func BenchmarkNewWriter(b *testing.B) {
 		}
 	}
 }
+
+func closeWriter(w *lolhtml.Writer) error {
+	return w.Close()
+}
+
+func BenchmarkNewWriter(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, handlers *lolhtml.Handlers) (
+		func() (*lolhtml.Writer, error),
+		func(*lolhtml.Writer) error,
+		func(),
+	) {
+		get := func() (*lolhtml.Writer, error) {
+			return lolhtml.NewWriter(nil, handlers)
+		}
+		return get, closeWriter, func() {}
+	})
+}
+
+func BenchmarkTemplate_NewWriter(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, handlers *lolhtml.Handlers) (
+		func() (*lolhtml.Writer, error),
+		func(*lolhtml.Writer) error,
+		func(),
+	) {
+		tmpl, err := lolhtml.Compile(handlers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		get := func() (*lolhtml.Writer, error) {
+			return tmpl.NewWriter(nil)
+		}
+		return get, closeWriter, tmpl.Free
+	})
+}
+
+func BenchmarkPool(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B, handlers *lolhtml.Handlers) (
+		func() (*lolhtml.Writer, error),
+		func(*lolhtml.Writer) error,
+		func(),
+	) {
+		tmpl, err := lolhtml.Compile(handlers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer tmpl.Free()
+		pool, err := lolhtml.NewPool(tmpl, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		get := func() (*lolhtml.Writer, error) {
+			return pool.Get(nil)
+		}
+		return get, pool.Put, pool.Free
+	})
+}
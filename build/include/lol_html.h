@@ -26,6 +26,7 @@ typedef struct lol_html_DocumentEnd lol_html_doc_end_t;
 typedef struct lol_html_Comment lol_html_comment_t;
 typedef struct lol_html_TextChunk lol_html_text_chunk_t;
 typedef struct lol_html_Element lol_html_element_t;
+typedef struct lol_html_EndTag lol_html_end_tag_t;
 typedef struct lol_html_AttributesIterator lol_html_attributes_iterator_t;
 typedef struct lol_html_Attribute lol_html_attribute_t;
 typedef struct lol_html_Selector lol_html_selector_t;
@@ -59,6 +60,16 @@ typedef struct {
     size_t len;
 } lol_html_text_chunk_content_t;
 
+// Location of a rewritable unit in the input, as byte offsets from the start
+// of the document.
+typedef struct {
+    // Offset of the first byte of the unit.
+    size_t start;
+
+    // Offset of the byte right after the last byte of the unit.
+    size_t end;
+} lol_html_source_location_bytes_t;
+
 // Utilities
 //---------------------------------------------------------------------
 
@@ -103,6 +114,11 @@ typedef lol_html_rewriter_directive_t (*lol_html_element_handler_t)(
     void *user_data
 );
 
+typedef lol_html_rewriter_directive_t (*lol_html_end_tag_handler_t)(
+    lol_html_end_tag_t *end_tag,
+    void *user_data
+);
+
 typedef lol_html_rewriter_directive_t (*lol_html_doc_end_handler_t)(
     lol_html_doc_end_t *doc_end,
     void *user_data
@@ -286,6 +302,9 @@ lol_html_str_t *lol_html_doctype_public_id_get(const lol_html_doctype_t *doctype
 // Returns NULL if the doctype doesn't have a SYSTEM identifier.
 lol_html_str_t *lol_html_doctype_system_id_get(const lol_html_doctype_t *doctype);
 
+// Returns the location of the doctype in the input.
+lol_html_source_location_bytes_t lol_html_doctype_source_location_bytes(const lol_html_doctype_t *doctype);
+
 // Attaches custom user data to the doctype.
 //
 // The same doctype can be passed to multiple handlers if it has been
@@ -299,6 +318,12 @@ void lol_html_doctype_user_data_set(
 // Returns user data attached to the doctype.
 void *lol_html_doctype_user_data_get(const lol_html_doctype_t *doctype);
 
+// Removes the doctype.
+void lol_html_doctype_remove(lol_html_doctype_t *doctype);
+
+// Returns `true` if the doctype has been removed.
+bool lol_html_doctype_is_removed(const lol_html_doctype_t *doctype);
+
 // Comment
 //---------------------------------------------------------------------
 
@@ -363,6 +388,9 @@ void lol_html_comment_remove(lol_html_comment_t *comment);
 // Returns `true` if the comment has been removed.
 bool lol_html_comment_is_removed(const lol_html_comment_t *comment);
 
+// Returns the location of the comment in the input.
+lol_html_source_location_bytes_t lol_html_comment_source_location_bytes(const lol_html_comment_t *comment);
+
 // Attaches custom user data to the comment.
 //
 // The same comment can be passed to multiple handlers if it has been
@@ -439,6 +467,9 @@ void lol_html_text_chunk_remove(lol_html_text_chunk_t *chunk);
 // Returns `true` if the text chunk has been removed.
 bool lol_html_text_chunk_is_removed(const lol_html_text_chunk_t *chunk);
 
+// Returns the location of the text chunk in the input.
+lol_html_source_location_bytes_t lol_html_text_chunk_source_location_bytes(const lol_html_text_chunk_t *chunk);
+
 // Attaches custom user data to the text chunk.
 //
 // The same text chunk can be passed to multiple handlers if it has been
@@ -646,6 +677,9 @@ void lol_html_element_remove_and_keep_content(const lol_html_element_t *element)
 // Returns `true` if the element has been removed.
 bool lol_html_element_is_removed(const lol_html_element_t *element);
 
+// Returns the location of the element's start tag in the input.
+lol_html_source_location_bytes_t lol_html_element_source_location_bytes(const lol_html_element_t *element);
+
 // Attaches custom user data to the element.
 //
 // The same element can be passed to multiple handlers if it has been
@@ -659,6 +693,78 @@ void lol_html_element_user_data_set(
 // Returns user data attached to the text chunk.
 void *lol_html_element_user_data_get(const lol_html_element_t *element);
 
+// Adds content handlers to the builder for the end tag of the given element.
+//
+// Subsequent calls to the method on the same element adds new handler.
+// They will run in the order in which they were registered.
+//
+// The handler can optionally have associated user data which will be
+// passed to the handler on each invocation along with the rewritable
+// unit argument.
+//
+// If the handler returns LOL_HTML_STOP directive then rewriting
+// stops immediately and `write()` or `end()` of the rewriter methods
+// return an error code.
+//
+// Returns 0 in case of success and -1 otherwise, e.g. if the element
+// can't have an end tag. The actual error message can be obtained using
+// `lol_html_take_last_error` function.
+int lol_html_element_add_end_tag_handler(
+    lol_html_element_t *element,
+    lol_html_end_tag_handler_t end_tag_handler,
+    void *user_data
+);
+
+// End tag
+//---------------------------------------------------------------------
+
+// Returns the end tag name.
+lol_html_str_t lol_html_end_tag_name_get(const lol_html_end_tag_t *end_tag);
+
+// Sets the tag name of the end tag.
+//
+// Name should be a valid UTF8-string.
+//
+// Returns 0 in case of success and -1 otherwise. The actual error message
+// can be obtained using `lol_html_take_last_error` function.
+int lol_html_end_tag_name_set(
+    lol_html_end_tag_t *end_tag,
+    const char *name,
+    size_t name_len
+);
+
+// Inserts the content string before the end tag either as raw text or as HTML.
+//
+// Content should be a valid UTF8-string.
+//
+// Returns 0 in case of success and -1 otherwise. The actual error message
+// can be obtained using `lol_html_take_last_error` function.
+int lol_html_end_tag_before(
+    lol_html_end_tag_t *end_tag,
+    const char *content,
+    size_t content_len,
+    bool is_html
+);
+
+// Inserts the content string right after the end tag as raw text or as HTML.
+//
+// Content should be a valid UTF8-string.
+//
+// Returns 0 in case of success and -1 otherwise. The actual error message
+// can be obtained using `lol_html_take_last_error` function.
+int lol_html_end_tag_after(
+    lol_html_end_tag_t *end_tag,
+    const char *content,
+    size_t content_len,
+    bool is_html
+);
+
+// Removes the end tag.
+void lol_html_end_tag_remove(lol_html_end_tag_t *end_tag);
+
+// Returns the location of the end tag in the input.
+lol_html_source_location_bytes_t lol_html_end_tag_source_location_bytes(const lol_html_end_tag_t *end_tag);
+
 // Inserts the content at the end of the document, either as raw text or as HTML.
 //
 // The content should be a valid UTF-8 string.
@@ -12,26 +12,27 @@ extern lol_html_rewriter_directive_t callback_doc_end(lol_html_doc_end_t *doc_en
 */
 import "C"
 import (
+	"runtime"
 	"unsafe"
 )
 
-// rewriterBuilder is used to build a rewriter.
+// rewriterBuilder is used to build a rewriter. It can build any number of rewriters, which
+// share the handlers registered on it.
 type rewriterBuilder struct {
 	rb       *C.lol_html_rewriter_builder_t
 	pointers []unsafe.Pointer
-	built    bool // this builder has built at least one writer
 }
 
 func newRewriterBuilder() *rewriterBuilder {
-	return &rewriterBuilder{rb: C.lol_html_rewriter_builder_new(), pointers: nil, built: false}
+	return &rewriterBuilder{rb: C.lol_html_rewriter_builder_new(), pointers: nil}
 }
 
+// Free frees the builder and the handlers registered on it. It must not be called
+// before all rewriters built by this builder have been freed, as they use the same handlers.
 func (rb *rewriterBuilder) Free() {
 	if rb != nil {
 		C.lol_html_rewriter_builder_free(rb.rb)
-		if !rb.built {
-			unrefPointers(rb.pointers)
-		}
+		unrefPointers(rb.pointers)
 	}
 }
 
@@ -83,7 +84,9 @@ func (rb *rewriterBuilder) AddElementContentHandlers(
 	elementHandler ElementHandlerFunc,
 	commentHandler CommentHandlerFunc,
 	textChunkHandler TextChunkHandlerFunc,
-) {
+) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	var cCallbackElementPointer, cCallbackCommentPointer, cCallbackTextChunkPointer *[0]byte
 	if elementHandler != nil {
 		cCallbackElementPointer = (*[0]byte)(C.callback_element)
@@ -97,7 +100,7 @@ func (rb *rewriterBuilder) AddElementContentHandlers(
 	elementHandlerPointer := savePointer(elementHandler)
 	commentHandlerPointer := savePointer(commentHandler)
 	textChunkHandlerPointer := savePointer(textChunkHandler)
-	C.lol_html_rewriter_builder_add_element_content_handlers(
+	errCode := C.lol_html_rewriter_builder_add_element_content_handlers(
 		rb.rb,
 		(*C.lol_html_selector_t)(selector),
 		cCallbackElementPointer,
@@ -108,9 +111,18 @@ func (rb *rewriterBuilder) AddElementContentHandlers(
 		textChunkHandlerPointer,
 	)
 	rb.pointers = append(rb.pointers, elementHandlerPointer, commentHandlerPointer, textChunkHandlerPointer)
+	if errCode == 0 {
+		return nil
+	}
+	return getError()
 }
 
-func (rb *rewriterBuilder) Build(sink OutputSink, config Config) (*rewriter, error) {
+// Build builds a rewriter for a Writer. writer is a pointer saved by the Writer, which is passed
+// to the output sink and handlers. It is owned by the caller and must stay valid until the rewriter
+// is freed.
+func (rb *rewriterBuilder) Build(writer unsafe.Pointer, config Config) (*rewriter, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	encodingC := C.CString(config.Encoding)
 	defer C.free(unsafe.Pointer(encodingC))
 	encodingLen := len(config.Encoding)
@@ -118,19 +130,17 @@ func (rb *rewriterBuilder) Build(sink OutputSink, config Config) (*rewriter, err
 		preallocated_parsing_buffer_size: C.size_t(config.Memory.PreallocatedParsingBufferSize),
 		max_allowed_memory_usage:         C.size_t(config.Memory.MaxAllowedMemoryUsage),
 	}
-	p := savePointer(sink)
 	r := C.lol_html_rewriter_build(
 		rb.rb,
 		encodingC,
 		C.size_t(encodingLen),
 		memorySettingsC,
 		(*[0]byte)(C.callback_sink),
-		p,
+		writer,
 		C.bool(config.Strict),
 	)
 	if r != nil {
-		rb.built = true
-		return &rewriter{rewriter: r, pointers: rb.pointers}, nil
+		return &rewriter{rewriter: r, writer: writer}, nil
 	}
 	return nil, getError()
 }
@@ -6,38 +6,95 @@ package lolhtml
 
 extern void callbackSink(const char *chunk, size_t chunk_len, void *);
 
-extern lol_html_rewriter_directive_t callbackDoctype(lol_html_doctype_t *doctype, void *user_data);
+extern lol_html_rewriter_directive_t callbackDoctype(lol_html_doctype_t *doctype, void *user_data, void *writer);
 
-extern lol_html_rewriter_directive_t callbackComment(lol_html_comment_t *comment, void *user_data);
+extern lol_html_rewriter_directive_t callbackComment(lol_html_comment_t *comment, void *user_data, void *writer);
 
-extern lol_html_rewriter_directive_t callbackTextChunk(lol_html_text_chunk_t *text_chunk, void *user_data);
+extern lol_html_rewriter_directive_t callbackTextChunk(lol_html_text_chunk_t *text_chunk, void *user_data, void *writer);
 
-extern lol_html_rewriter_directive_t callbackElement(lol_html_element_t *element, void *user_data);
+extern lol_html_rewriter_directive_t callbackElement(lol_html_element_t *element, void *user_data, void *writer);
 
-extern lol_html_rewriter_directive_t callbackDocumentEnd(lol_html_doc_end_t *doc_end, void *user_data);
+extern lol_html_rewriter_directive_t callbackEndTag(lol_html_end_tag_t *end_tag, void *user_data, void *writer);
+
+extern lol_html_rewriter_directive_t callbackDocumentEnd(lol_html_doc_end_t *doc_end, void *user_data, void *writer);
+
+// Handlers are registered on a builder and shared by all rewriters built from it, so the user
+// data of a handler can't tell which Writer it is called for. The Writer currently feeding a
+// rewriter is kept here instead. Handlers are always called on the thread that called
+// lol_html_rewriter_write() or lol_html_rewriter_end(), and so is the Go callback.
+static __thread void *current_writer = NULL;
+
+void *current_writer_get() {
+    return current_writer;
+}
+
+int rewriter_write(lol_html_rewriter_t *rewriter, const char *chunk, size_t chunk_len, void *writer) {
+    void *prev = current_writer;
+    current_writer = writer;
+    int code = lol_html_rewriter_write(rewriter, chunk, chunk_len);
+    current_writer = prev;
+    return code;
+}
+
+int rewriter_end(lol_html_rewriter_t *rewriter, void *writer) {
+    void *prev = current_writer;
+    current_writer = writer;
+    int code = lol_html_rewriter_end(rewriter);
+    current_writer = prev;
+    return code;
+}
 
 void callback_sink(const char *chunk, size_t chunk_len, void *user_data) {
     return callbackSink(chunk, chunk_len, user_data);
 }
 
 lol_html_rewriter_directive_t callback_doctype(lol_html_doctype_t *doctype, void *user_data) {
-    return callbackDoctype(doctype, user_data);
+    return callbackDoctype(doctype, user_data, current_writer);
 }
 
 lol_html_rewriter_directive_t callback_comment(lol_html_comment_t *comment, void *user_data) {
-    return callbackComment(comment, user_data);
+    return callbackComment(comment, user_data, current_writer);
 }
 
 lol_html_rewriter_directive_t callback_text_chunk(lol_html_text_chunk_t *text_chunk, void *user_data) {
-    return callbackTextChunk(text_chunk, user_data);
+    return callbackTextChunk(text_chunk, user_data, current_writer);
 }
 
 lol_html_rewriter_directive_t callback_element(lol_html_element_t *element, void *user_data){
-    return callbackElement(element, user_data);
+    return callbackElement(element, user_data, current_writer);
+}
+
+lol_html_rewriter_directive_t callback_end_tag(lol_html_end_tag_t *end_tag, void *user_data) {
+    return callbackEndTag(end_tag, user_data, current_writer);
 }
 
 lol_html_rewriter_directive_t callback_doc_end(lol_html_doc_end_t *doc_end, void *user_data) {
-    return callbackDocumentEnd(doc_end, user_data);
+    return callbackDocumentEnd(doc_end, user_data, current_writer);
 }
 */
 import "C"
+import "context"
+
+// currentWriter returns the Writer whose handler is being called on this thread, or nil if
+// called outside of handlers.
+func currentWriter() *Writer {
+	return restoreWriter(C.current_writer_get())
+}
+
+// currentContext returns the context of the Writer whose handler is being called, or
+// context.Background() if there is none.
+func currentContext() context.Context {
+	if w := currentWriter(); w != nil && w.ctx != nil {
+		return w.ctx
+	}
+	return context.Background()
+}
+
+// currentState returns the state of the Writer whose handler is being called, or nil if there is
+// none.
+func currentState() interface{} {
+	if w := currentWriter(); w != nil {
+		return w.state
+	}
+	return nil
+}
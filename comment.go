@@ -5,7 +5,11 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
-import "unsafe"
+import (
+	"context"
+	"runtime"
+	"unsafe"
+)
 
 // Comment represents an HTML comment.
 type Comment C.lol_html_comment_t
@@ -14,8 +18,54 @@ type Comment C.lol_html_comment_t
 // Expected to return a RewriterDirective as instruction to continue or stop.
 type CommentHandlerFunc func(*Comment) RewriterDirective
 
+// CommentHandlerFuncE is like CommentHandlerFunc, but returns an error instead of a RewriterDirective.
+// A non-nil error stops the rewriter, and is returned by Writer.Write and Writer.Close wrapped in
+// a *HandlerError.
+type CommentHandlerFuncE func(*Comment) error
+
+// adapt returns a CommentHandlerFunc recording the error returned by f as a *HandlerError.
+func (f CommentHandlerFuncE) adapt(selector string) CommentHandlerFunc {
+	return func(c *Comment) RewriterDirective {
+		return handlerResult(HandlerKindComment, selector, f(c))
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (c *Comment) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (c *Comment) State() interface{} {
+	return currentState()
+}
+
+// SourceLocation returns the location of the comment in the input.
+func (c *Comment) SourceLocation() SourceLocation {
+	checkHandle(unsafe.Pointer(c), "comment")
+	return newSourceLocation(C.lol_html_comment_source_location_bytes((*C.lol_html_comment_t)(c)))
+}
+
+// SetUserData attaches v to the Comment, so that other handlers called with the same Comment can
+// retrieve it with UserData. v is kept alive until the Writer is closed or reset.
+// It is only valid to call SetUserData inside handlers.
+func (c *Comment) SetUserData(v interface{}) {
+	checkHandle(unsafe.Pointer(c), "comment")
+	C.lol_html_comment_user_data_set((*C.lol_html_comment_t)(c), saveUserData(v))
+}
+
+// UserData returns the value attached to the Comment by SetUserData, or nil if there is none.
+func (c *Comment) UserData() interface{} {
+	checkHandle(unsafe.Pointer(c), "comment")
+	return restorePointer(C.lol_html_comment_user_data_get((*C.lol_html_comment_t)(c)))
+}
+
 // Text returns the comment's text.
 func (c *Comment) Text() string {
+	checkHandle(unsafe.Pointer(c), "comment")
 	textC := (str)(C.lol_html_comment_text_get((*C.lol_html_comment_t)(c)))
 	defer textC.Free()
 	return textC.String()
@@ -23,6 +73,9 @@ func (c *Comment) Text() string {
 
 // SetText sets the comment's text and returns an error if there is one.
 func (c *Comment) SetText(text string) error {
+	checkHandle(unsafe.Pointer(c), "comment")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	textC := C.CString(text)
 	defer C.free(unsafe.Pointer(textC))
 	textLen := len(text)
@@ -42,6 +95,9 @@ const (
 )
 
 func (c *Comment) alter(content string, alter commentAlter, isHTML bool) error {
+	checkHandle(unsafe.Pointer(c), "comment")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	contentC := C.CString(content)
 	defer C.free(unsafe.Pointer(contentC))
 	contentLen := len(content)
@@ -121,10 +177,12 @@ func (c *Comment) ReplaceAsHTML(content string) error {
 
 // Remove removes the comment.
 func (c *Comment) Remove() {
+	checkHandle(unsafe.Pointer(c), "comment")
 	C.lol_html_comment_remove((*C.lol_html_comment_t)(c))
 }
 
 // IsRemoved returns whether the comment is removed or not.
 func (c *Comment) IsRemoved() bool {
+	checkHandle(unsafe.Pointer(c), "comment")
 	return (bool)(C.lol_html_comment_is_removed((*C.lol_html_comment_t)(c)))
 }
@@ -5,6 +5,7 @@ package lolhtml
 */
 import "C"
 import (
+	"fmt"
 	"unsafe"
 )
 
@@ -14,10 +15,25 @@ type Config struct {
 	Encoding string
 	// defaults to PreallocatedParsingBufferSize: 1024, MaxAllowedMemoryUsage: 1<<63 - 1.
 	Memory *MemorySettings
-	// defaults to func([]byte) {}. In other words, totally discard output.
+	// defaults to nil. If set, takes precedence over the io.Writer given to NewWriter.
+	// Output is discarded if neither is set.
 	Sink OutputSink
+	// defaults to nil. If set, takes precedence over Sink.
+	SinkE OutputSinkE
 	// defaults to true. If true, bail out for security reasons when ambiguous.
 	Strict bool
+	// defaults to nil, i.e. the destination of a Writer is only flushed by calling Writer.Flush.
+	AutoFlush *FlushPolicy
+	// defaults to false. If true, the elements enclosing an element are tracked, see Element.Ancestors.
+	TrackAncestors bool
+	// defaults to false. If true, and all ElementContentHandlers only have a limited element handler
+	// (see ElementContentHandler.Limit), the rest of a document is copied to the output as is once
+	// all of them are exhausted and the end tag handlers they registered have been called, rather
+	// than being parsed. There must be no DocumentContentHandler.
+	PassThroughWhenExhausted bool
+	// defaults to false. If true, panics in handlers and output sinks are not recovered,
+	// and crash the program.
+	DisablePanicRecovery bool
 }
 
 func newDefaultConfig() Config {
@@ -27,7 +43,6 @@ func newDefaultConfig() Config {
 			PreallocatedParsingBufferSize: 1024,
 			MaxAllowedMemoryUsage:         1<<63 - 1,
 		},
-		Sink:   func([]byte) {},
 		Strict: true,
 	}
 }
@@ -38,6 +53,13 @@ type MemorySettings struct {
 	MaxAllowedMemoryUsage         int // defaults to 1<<63 -1
 }
 
+// FlushPolicy sets when a Writer flushes its destination automatically, see Writer.Flush.
+// The destination is also flushed when the Writer is closed if a FlushPolicy is set.
+type FlushPolicy struct {
+	AfterWrite bool // flush after each call to Write that produced output
+	AfterBytes int  // flush as soon as this many bytes of output are written since the last flush, 0 disables
+}
+
 // OutputSink is a callback function where output is written to. A byte slice is passed each time,
 // representing a chunk of output.
 //
@@ -45,21 +67,71 @@ type MemorySettings struct {
 // individually. For most common uses, NewWriter would be more convenient.
 type OutputSink func([]byte)
 
+// OutputSinkE is like OutputSink, but can report an error. After an error is returned, the
+// rewriter stops at the next handler call, no more output is passed to the sink, and the error
+// is returned by Writer.Write and Writer.Close wrapped in a *SinkError.
+type OutputSinkE func([]byte) error
+
 // DocumentContentHandler is a group of handlers that would be applied to the whole HTML document.
+//
+// Each handler can be given either as a function returning a RewriterDirective, or as its
+// error-returning variant (the fields ending in E), but not both.
 type DocumentContentHandler struct {
-	DoctypeHandler     DoctypeHandlerFunc
-	CommentHandler     CommentHandlerFunc
-	TextChunkHandler   TextChunkHandlerFunc
-	DocumentEndHandler DocumentEndHandlerFunc
+	DocumentStartHandler DocumentStartHandlerFunc
+	DoctypeHandler       DoctypeHandlerFunc
+	CommentHandler       CommentHandlerFunc
+	TextChunkHandler     TextChunkHandlerFunc
+	DocumentEndHandler   DocumentEndHandlerFunc
+
+	DocumentStartHandlerE DocumentStartHandlerFuncE
+	DoctypeHandlerE       DoctypeHandlerFuncE
+	CommentHandlerE       CommentHandlerFuncE
+	TextChunkHandlerE     TextChunkHandlerFuncE
+	DocumentEndHandlerE   DocumentEndHandlerFuncE
+
+	// TextTypes restricts the text chunk handler to text chunks of the given types, e.g.
+	// TextTypeData|TextTypeRCData to leave scripts and styles alone. Defaults to 0, i.e. all types.
+	TextTypes TextType
 }
 
 // ElementContentHandler is a group of handlers that would be applied to the content matched by
 // the given selector.
+//
+// Each handler can be given either as a function returning a RewriterDirective, or as its
+// error-returning variant (the fields ending in E), but not both.
 type ElementContentHandler struct {
-	Selector         string
+	// Selector can use the structural pseudo-classes :first-child, :nth-child(an+b), :first-of-type
+	// and :nth-of-type(an+b) on its last compound selector, and a sibling combinator before it, as
+	// in "h2 + p:first-of-type". These are evaluated by the binding, and only element handlers are
+	// supported with them. Pseudo-classes depending on content after the element, like :last-child
+	// or :has(), are rejected with a SelectorError.
+	Selector string
+	// ParsedSelector can be set instead of Selector, to use a Selector parsed beforehand. Selector
+	// lists given this way are not split, so Element.MatchedSelector reports the whole list, unless
+	// they contain extended selectors.
+	ParsedSelector *Selector
+
 	ElementHandler   ElementHandlerFunc
 	CommentHandler   CommentHandlerFunc
 	TextChunkHandler TextChunkHandlerFunc
+
+	ElementHandlerE   ElementHandlerFuncE
+	CommentHandlerE   CommentHandlerFuncE
+	TextChunkHandlerE TextChunkHandlerFuncE
+
+	// Limit is the maximum number of elements the element handler is called for in a document.
+	// Defaults to 0, i.e. no limit. Comment and text chunk handlers are not limited.
+	Limit int
+	// Nth makes the element handler skip the elements matched before the Nth one in a document,
+	// 1-based. The element handler is only called for the Nth element, unless Limit is set too.
+	Nth int
+	// Once is a shorthand for a Limit of 1.
+	Once bool
+
+	// OnNoMatch is called at the end of a document in which the selector matched no element, e.g. to
+	// append fallback content with DocumentEnd.AppendAsHTML. Elements skipped because of Limit or Nth
+	// still count as matches.
+	OnNoMatch DocumentEndHandlerFunc
 }
 
 // Handlers contain DocumentContentHandlers and ElementContentHandlers. Can contain arbitrary numbers
@@ -69,39 +141,202 @@ type Handlers struct {
 	ElementContentHandler  []ElementContentHandler
 }
 
+// selector returns the source of the selector of eh.
+func (eh *ElementContentHandler) selector() string {
+	if eh.ParsedSelector != nil {
+		return eh.ParsedSelector.String()
+	}
+	return eh.Selector
+}
+
+// errBothHandlers returns the error for a handler given in both variants.
+func errBothHandlers(kind HandlerKind, selector string) error {
+	if selector == "" {
+		return fmt.Errorf("both the document %s handler and its error-returning variant are set", kind)
+	}
+	return fmt.Errorf("both the %s handler and its error-returning variant are set for selector %q", kind, selector)
+}
+
+// handlers returns the handlers of dh, with error-returning variants adapted.
+func (dh *DocumentContentHandler) handlers() (
+	doctype DoctypeHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+	documentEnd DocumentEndHandlerFunc,
+	err error,
+) {
+	doctype, comment, textChunk, documentEnd = dh.DoctypeHandler, dh.CommentHandler, dh.TextChunkHandler, dh.DocumentEndHandler
+	if dh.DoctypeHandlerE != nil {
+		if doctype != nil {
+			return nil, nil, nil, nil, errBothHandlers(HandlerKindDoctype, "")
+		}
+		doctype = dh.DoctypeHandlerE.adapt("")
+	}
+	if dh.CommentHandlerE != nil {
+		if comment != nil {
+			return nil, nil, nil, nil, errBothHandlers(HandlerKindComment, "")
+		}
+		comment = dh.CommentHandlerE.adapt("")
+	}
+	if dh.TextChunkHandlerE != nil {
+		if textChunk != nil {
+			return nil, nil, nil, nil, errBothHandlers(HandlerKindTextChunk, "")
+		}
+		textChunk = dh.TextChunkHandlerE.adapt("")
+	}
+	if dh.DocumentEndHandlerE != nil {
+		if documentEnd != nil {
+			return nil, nil, nil, nil, errBothHandlers(HandlerKindDocumentEnd, "")
+		}
+		documentEnd = dh.DocumentEndHandlerE.adapt("")
+	}
+	return
+}
+
+// startHandler returns the document start handler of dh, with its error-returning variant adapted.
+func (dh *DocumentContentHandler) startHandler() (DocumentStartHandlerFunc, error) {
+	if dh.DocumentStartHandlerE == nil {
+		return dh.DocumentStartHandler, nil
+	}
+	if dh.DocumentStartHandler != nil {
+		return nil, errBothHandlers(HandlerKindDocumentStart, "")
+	}
+	return dh.DocumentStartHandlerE.adapt(), nil
+}
+
+// handlers returns the handlers of eh, with error-returning variants adapted.
+func (eh *ElementContentHandler) handlers() (
+	element ElementHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+	err error,
+) {
+	element, comment, textChunk = eh.ElementHandler, eh.CommentHandler, eh.TextChunkHandler
+	if eh.ElementHandlerE != nil {
+		if element != nil {
+			return nil, nil, nil, errBothHandlers(HandlerKindElement, eh.selector())
+		}
+		element = eh.ElementHandlerE.adapt(eh.selector())
+	}
+	if eh.CommentHandlerE != nil {
+		if comment != nil {
+			return nil, nil, nil, errBothHandlers(HandlerKindComment, eh.selector())
+		}
+		comment = eh.CommentHandlerE.adapt(eh.selector())
+	}
+	if eh.TextChunkHandlerE != nil {
+		if textChunk != nil {
+			return nil, nil, nil, errBothHandlers(HandlerKindTextChunk, eh.selector())
+		}
+		textChunk = eh.TextChunkHandlerE.adapt(eh.selector())
+	}
+	return
+}
+
+// handlerResult is called by error-returning handlers with the error they returned.
+// A non-nil error is recorded on the current Writer as a *HandlerError.
+func handlerResult(kind HandlerKind, selector string, err error) RewriterDirective {
+	if err == nil {
+		return Continue
+	}
+	currentWriter().fail(&HandlerError{Kind: kind, Selector: selector, Err: err})
+	return Stop
+}
+
+// restoreWriter returns the Writer of a pointer saved by the Writer, or nil if there is none.
+func restoreWriter(ptr unsafe.Pointer) *Writer {
+	w, _ := restorePointer(ptr).(*Writer)
+	return w
+}
+
 //export callbackSink
 func callbackSink(chunk *C.char, chunkLen C.size_t, userData unsafe.Pointer) {
 	c := C.GoBytes(unsafe.Pointer(chunk), C.int(chunkLen))
-	cb := restorePointer(userData).(OutputSink)
-	cb(c)
+	w := restorePointer(userData).(*Writer)
+	defer w.recoverPanic(nil)
+	w.writeRewritten(c)
 }
 
 //export callbackDoctype
-func callbackDoctype(doctype *Doctype, userData unsafe.Pointer) RewriterDirective {
+func callbackDoctype(doctype *Doctype, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(doctype))
+	defer exitHandle(unsafe.Pointer(doctype))
+	defer w.finish(&d, doctype)
 	cb := restorePointer(userData).(DoctypeHandlerFunc)
 	return cb(doctype)
 }
 
 //export callbackComment
-func callbackComment(comment *Comment, userData unsafe.Pointer) RewriterDirective {
+func callbackComment(comment *Comment, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(comment))
+	defer exitHandle(unsafe.Pointer(comment))
+	defer w.finish(&d, comment)
 	cb := restorePointer(userData).(CommentHandlerFunc)
 	return cb(comment)
 }
 
 //export callbackTextChunk
-func callbackTextChunk(textChunk *TextChunk, userData unsafe.Pointer) RewriterDirective {
+func callbackTextChunk(textChunk *TextChunk, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(textChunk))
+	defer exitHandle(unsafe.Pointer(textChunk))
+	defer w.finish(&d, textChunk)
 	cb := restorePointer(userData).(TextChunkHandlerFunc)
 	return cb(textChunk)
 }
 
 //export callbackElement
-func callbackElement(element *Element, userData unsafe.Pointer) RewriterDirective {
+func callbackElement(element *Element, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(element))
+	defer exitHandle(unsafe.Pointer(element))
+	defer w.finish(&d, element)
 	cb := restorePointer(userData).(ElementHandlerFunc)
 	return cb(element)
 }
 
+//export callbackEndTag
+func callbackEndTag(endTag *EndTag, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(endTag))
+	defer exitHandle(unsafe.Pointer(endTag))
+	defer w.finish(&d, endTag)
+	switch cb := restorePointer(userData).(type) {
+	case EndTagHandlerFunc:
+		return cb(endTag)
+	case *openElement:
+		w.closeElement(cb)
+	}
+	// nothing is left to do for an element that has been closed implicitly, see trackAncestors
+	return Continue
+}
+
 //export callbackDocumentEnd
-func callbackDocumentEnd(documentEnd *DocumentEnd, userData unsafe.Pointer) RewriterDirective {
+func callbackDocumentEnd(documentEnd *DocumentEnd, userData unsafe.Pointer, writer unsafe.Pointer) (d RewriterDirective) {
+	w := restoreWriter(writer)
+	if w.stopping() {
+		return Stop
+	}
+	enterHandle(unsafe.Pointer(documentEnd))
+	defer exitHandle(unsafe.Pointer(documentEnd))
+	defer w.finish(&d, nil)
 	cb := restorePointer(userData).(DocumentEndHandlerFunc)
 	return cb(documentEnd)
 }
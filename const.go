@@ -11,6 +11,6 @@ const (
 	Continue RewriterDirective = iota
 
 	// Stop stops the rewriter immediately. Content currently buffered is discarded, and an error is returned.
-	// After stopping, the Writer should not be used anymore except for Close().
+	// After stopping, every use of the Writer returns the error, so only Close() is useful.
 	Stop
 )
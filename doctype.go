@@ -4,6 +4,11 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
+import (
+	"context"
+	"strings"
+	"unsafe"
+)
 
 // Doctype represents the document's doctype.
 type Doctype C.lol_html_doctype_t
@@ -11,8 +16,54 @@ type Doctype C.lol_html_doctype_t
 // DoctypeHandlerFunc is a callback handler function to do something with a Comment.
 type DoctypeHandlerFunc func(*Doctype) RewriterDirective
 
+// DoctypeHandlerFuncE is like DoctypeHandlerFunc, but returns an error instead of a RewriterDirective.
+// A non-nil error stops the rewriter, and is returned by Writer.Write and Writer.Close wrapped in
+// a *HandlerError.
+type DoctypeHandlerFuncE func(*Doctype) error
+
+// adapt returns a DoctypeHandlerFunc recording the error returned by f as a *HandlerError.
+func (f DoctypeHandlerFuncE) adapt(selector string) DoctypeHandlerFunc {
+	return func(d *Doctype) RewriterDirective {
+		return handlerResult(HandlerKindDoctype, selector, f(d))
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (d *Doctype) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (d *Doctype) State() interface{} {
+	return currentState()
+}
+
+// SourceLocation returns the location of the doctype in the input.
+func (d *Doctype) SourceLocation() SourceLocation {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	return newSourceLocation(C.lol_html_doctype_source_location_bytes((*C.lol_html_doctype_t)(d)))
+}
+
+// SetUserData attaches v to the Doctype, so that other handlers called with the same Doctype can
+// retrieve it with UserData. v is kept alive until the Writer is closed or reset.
+// It is only valid to call SetUserData inside handlers.
+func (d *Doctype) SetUserData(v interface{}) {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	C.lol_html_doctype_user_data_set((*C.lol_html_doctype_t)(d), saveUserData(v))
+}
+
+// UserData returns the value attached to the Doctype by SetUserData, or nil if there is none.
+func (d *Doctype) UserData() interface{} {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	return restorePointer(C.lol_html_doctype_user_data_get((*C.lol_html_doctype_t)(d)))
+}
+
 // Name returns doctype name.
 func (d *Doctype) Name() string {
+	checkHandle(unsafe.Pointer(d), "doctype")
 	nameC := (*str)(C.lol_html_doctype_name_get((*C.lol_html_doctype_t)(d)))
 	defer nameC.Free()
 	return nameC.String()
@@ -20,6 +71,7 @@ func (d *Doctype) Name() string {
 
 // PublicID returns doctype public ID.
 func (d *Doctype) PublicID() string {
+	checkHandle(unsafe.Pointer(d), "doctype")
 	nameC := (*str)(C.lol_html_doctype_public_id_get((*C.lol_html_doctype_t)(d)))
 	defer nameC.Free()
 	return nameC.String()
@@ -27,7 +79,132 @@ func (d *Doctype) PublicID() string {
 
 // SystemID returns doctype system ID.
 func (d *Doctype) SystemID() string {
+	checkHandle(unsafe.Pointer(d), "doctype")
 	nameC := (*str)(C.lol_html_doctype_system_id_get((*C.lol_html_doctype_t)(d)))
 	defer nameC.Free()
 	return nameC.String()
 }
+
+// textEscaper escapes content inserted as text by the binding, as lol_html does.
+var textEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;")
+
+// Remove removes the doctype.
+func (d *Doctype) Remove() {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	C.lol_html_doctype_remove((*C.lol_html_doctype_t)(d))
+	if w := currentWriter(); w != nil {
+		w.doctypeRemoved = true
+	}
+}
+
+// IsRemoved returns whether the doctype is removed or not.
+func (d *Doctype) IsRemoved() bool {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	return (bool)(C.lol_html_doctype_is_removed((*C.lol_html_doctype_t)(d)))
+}
+
+// lol_html can't insert content around a doctype, so the binding writes it to the output itself.
+// This relies on the order in which lol_html writes its output: doctype handlers are called once
+// the content before the doctype has been written, and the doctype is then written as a chunk of
+// its own, unless removed. So content inserted before the doctype is written right away, and
+// content inserted after it is written with the next chunk of output, see Writer.writeRewritten.
+// This holds wherever the doctype is in the document, but content inserted by handlers of other
+// lol_html versions, e.g. at the end of an element, may change it.
+
+func (d *Doctype) insertBefore(content string) {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	if w := currentWriter(); w != nil {
+		w.writeOutput([]byte(content))
+	}
+}
+
+func (d *Doctype) insertAfter(content string) {
+	checkHandle(unsafe.Pointer(d), "doctype")
+	if w := currentWriter(); w != nil {
+		w.afterDoctype = append(w.afterDoctype, content...)
+	}
+}
+
+// InsertBeforeAsText inserts the given content before the doctype.
+//
+// The content is HTML-escaped before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+//
+// The content is written to the output right away, as lol_html has written the content before
+// the doctype when the handler is called.
+//
+// It is only valid to call InsertBeforeAsText inside handlers.
+func (d *Doctype) InsertBeforeAsText(content string) error {
+	d.insertBefore(textEscaper.Replace(content))
+	return nil
+}
+
+// InsertBeforeAsHTML inserts the given content before the doctype.
+// The content is inserted as is, and written to the output right away, see InsertBeforeAsText.
+// It is only valid to call InsertBeforeAsHTML inside handlers.
+func (d *Doctype) InsertBeforeAsHTML(content string) error {
+	d.insertBefore(content)
+	return nil
+}
+
+// InsertAfterAsText inserts the given content after the doctype.
+//
+// The content is HTML-escaped before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+//
+// The content is written to the output together with the next chunk written by lol_html, which
+// is the doctype itself, or the content after it if the doctype is removed.
+//
+// It is only valid to call InsertAfterAsText inside handlers.
+func (d *Doctype) InsertAfterAsText(content string) error {
+	d.insertAfter(textEscaper.Replace(content))
+	return nil
+}
+
+// InsertAfterAsHTML inserts the given content after the doctype.
+// The content is inserted as is, with the next chunk of output, see InsertAfterAsText.
+// It is only valid to call InsertAfterAsHTML inside handlers.
+func (d *Doctype) InsertAfterAsHTML(content string) error {
+	d.insertAfter(content)
+	return nil
+}
+
+// ReplaceAsText replaces the doctype with the supplied content, e.g. to replace a legacy XHTML
+// doctype with "<!DOCTYPE html>".
+//
+// The content is HTML-escaped before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+//
+// The content is written to the output right away, before the content after the doctype, and the
+// doctype is removed, see InsertBeforeAsText.
+//
+// It is only valid to call ReplaceAsText inside handlers.
+func (d *Doctype) ReplaceAsText(content string) error {
+	d.insertBefore(textEscaper.Replace(content))
+	d.Remove()
+	return nil
+}
+
+// ReplaceAsHTML replaces the doctype with the supplied content.
+// The content is kept as is, and written to the output right away, see ReplaceAsText.
+// It is only valid to call ReplaceAsHTML inside handlers.
+func (d *Doctype) ReplaceAsHTML(content string) error {
+	d.insertBefore(content)
+	d.Remove()
+	return nil
+}
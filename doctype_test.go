@@ -1,6 +1,7 @@
 package lolhtml_test
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -74,3 +75,85 @@ func TestDoctype_StopRewriting(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestDoctype_Mutations(t *testing.T) {
+	const xhtml = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`
+	testCases := []struct {
+		name   string
+		mutate func(d *lolhtml.Doctype) error
+		output string
+	}{
+		{"Remove", func(d *lolhtml.Doctype) error {
+			d.Remove()
+			if !d.IsRemoved() {
+				t.Error("doctype not removed")
+			}
+			return nil
+		}, "\n<p>1 &amp; 2</p>"},
+		{"ReplaceAsHTML", func(d *lolhtml.Doctype) error {
+			return d.ReplaceAsHTML("<!DOCTYPE html>")
+		}, "<!DOCTYPE html>\n<p>1 &amp; 2</p>"},
+		{"ReplaceAsText", func(d *lolhtml.Doctype) error {
+			return d.ReplaceAsText("<&>")
+		}, "&lt;&amp;&gt;\n<p>1 &amp; 2</p>"},
+		{"InsertBeforeAsHTML", func(d *lolhtml.Doctype) error {
+			return d.InsertBeforeAsHTML("<!-- before -->")
+		}, "<!-- before -->" + xhtml + "\n<p>1 &amp; 2</p>"},
+		{"InsertAfterAsHTML", func(d *lolhtml.Doctype) error {
+			return d.InsertAfterAsHTML("<!-- after -->")
+		}, xhtml + "<!-- after -->\n<p>1 &amp; 2</p>"},
+		{"InsertAfterAsTextAndRemove", func(d *lolhtml.Doctype) error {
+			if err := d.InsertAfterAsText("<after>"); err != nil {
+				return err
+			}
+			d.Remove()
+			return nil
+		}, "&lt;after&gt;\n<p>1 &amp; 2</p>"},
+	}
+	for _, tc := range testCases {
+		output, err := lolhtml.RewriteString(xhtml+"\n<p>1 &amp; 2</p>", &lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{DoctypeHandlerE: tc.mutate},
+			},
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if output != tc.output {
+			t.Errorf("%s: got %q; want %q", tc.name, output, tc.output)
+		}
+	}
+}
+
+// TestDoctype_MutationsInTheMiddle checks the order of the content inserted around a doctype that
+// is not at the start of the input, split across chunks.
+func TestDoctype_MutationsInTheMiddle(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(&buf, &lolhtml.Handlers{
+		DocumentContentHandler: []lolhtml.DocumentContentHandler{
+			{
+				DoctypeHandlerE: func(d *lolhtml.Doctype) error {
+					if err := d.InsertBeforeAsHTML("<!--before-->"); err != nil {
+						return err
+					}
+					return d.InsertAfterAsHTML("<!--after-->")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"<p>1</p>\n<!DOC", "TYPE html>", "<p>2</p>"} {
+		if _, err = w.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if want := "<p>1</p>\n<!--before--><!DOCTYPE html><!--after--><p>2</p>"; buf.String() != want {
+		t.Errorf("got %q; want %q", buf.String(), want)
+	}
+}
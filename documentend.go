@@ -5,7 +5,11 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
-import "unsafe"
+import (
+	"context"
+	"runtime"
+	"unsafe"
+)
 
 // DocumentEnd represents the end of the document.
 type DocumentEnd C.lol_html_doc_end_t
@@ -13,6 +17,31 @@ type DocumentEnd C.lol_html_doc_end_t
 // DocumentEndHandlerFunc is a callback handler function to do something with a DocumentEnd.
 type DocumentEndHandlerFunc func(*DocumentEnd) RewriterDirective
 
+// DocumentEndHandlerFuncE is like DocumentEndHandlerFunc, but returns an error instead of a RewriterDirective.
+// A non-nil error stops the rewriter, and is returned by Writer.Write and Writer.Close wrapped in
+// a *HandlerError.
+type DocumentEndHandlerFuncE func(*DocumentEnd) error
+
+// adapt returns a DocumentEndHandlerFunc recording the error returned by f as a *HandlerError.
+func (f DocumentEndHandlerFuncE) adapt(selector string) DocumentEndHandlerFunc {
+	return func(d *DocumentEnd) RewriterDirective {
+		return handlerResult(HandlerKindDocumentEnd, selector, f(d))
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (d *DocumentEnd) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (d *DocumentEnd) State() interface{} {
+	return currentState()
+}
+
 // AppendAsText appends the given content at the end of the document.
 //
 // The rewriter will HTML-escape the content before appending:
@@ -23,6 +52,9 @@ type DocumentEndHandlerFunc func(*DocumentEnd) RewriterDirective
 //
 // `&` will be replaced with `&amp;`
 func (d *DocumentEnd) AppendAsText(content string) error {
+	checkHandle(unsafe.Pointer(d), "document end")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	contentC := C.CString(content)
 	defer C.free(unsafe.Pointer(contentC))
 	contentLen := len(content)
@@ -36,6 +68,9 @@ func (d *DocumentEnd) AppendAsText(content string) error {
 // AppendAsHTML appends the given content at the end of the document.
 // The content is appended as is.
 func (d *DocumentEnd) AppendAsHTML(content string) error {
+	checkHandle(unsafe.Pointer(d), "document end")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	contentC := C.CString(content)
 	defer C.free(unsafe.Pointer(contentC))
 	contentLen := len(content)
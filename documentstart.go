@@ -0,0 +1,111 @@
+package lolhtml
+
+import "context"
+
+// DocumentStart represents the start of the document. Unlike other content, it is not produced
+// by lol_html: DocumentStart handlers are called by the Writer before the first chunk of input is
+// written to the rewriter, or when the Writer is closed if nothing was written.
+//
+// As nothing has been parsed yet, DocumentStart handlers can't know whether the document starts
+// with a doctype, and content they prepend comes before it, which makes browsers render the
+// document in quirks mode. To insert content after the doctype, use a Doctype handler with
+// Doctype.InsertAfterAsHTML instead, or buffer the document.
+type DocumentStart struct {
+	w       *Writer
+	content []byte
+}
+
+// DocumentStartHandlerFunc is a callback handler function to do something with a DocumentStart.
+type DocumentStartHandlerFunc func(*DocumentStart) RewriterDirective
+
+// DocumentStartHandlerFuncE is like DocumentStartHandlerFunc, but returns an error instead of a
+// RewriterDirective. A non-nil error stops the rewriter, and is returned by Writer.Write and
+// Writer.Close wrapped in a *HandlerError.
+type DocumentStartHandlerFuncE func(*DocumentStart) error
+
+// adapt returns a DocumentStartHandlerFunc recording the error returned by f as a *HandlerError.
+// DocumentStart handlers are not called by lol_html, so the error is recorded on the Writer of
+// the DocumentStart rather than on the current Writer.
+func (f DocumentStartHandlerFuncE) adapt() DocumentStartHandlerFunc {
+	return func(d *DocumentStart) RewriterDirective {
+		if err := f(d); err != nil {
+			d.w.fail(&HandlerError{Kind: HandlerKindDocumentStart, Err: err})
+			return Stop
+		}
+		return Continue
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (d *DocumentStart) Context() context.Context {
+	if d.w != nil && d.w.ctx != nil {
+		return d.w.ctx
+	}
+	return context.Background()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (d *DocumentStart) State() interface{} {
+	if d.w == nil {
+		return nil
+	}
+	return d.w.state
+}
+
+// PrependAsText inserts the given content at the start of the document, before any rewritten
+// output. Content prepended by successive calls, in the same or different handlers, is written
+// in the order of the calls.
+//
+// The content is HTML-escaped before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+func (d *DocumentStart) PrependAsText(content string) error {
+	d.content = append(d.content, textEscaper.Replace(content)...)
+	return nil
+}
+
+// PrependAsHTML inserts the given content at the start of the document, before any rewritten
+// output, e.g. "<!DOCTYPE html>" for documents known to have none. The content is inserted as is.
+func (d *DocumentStart) PrependAsHTML(content string) error {
+	d.content = append(d.content, content...)
+	return nil
+}
+
+// startDocument calls the DocumentStart handlers once per document, and writes the content they
+// prepended.
+func (w *Writer) startDocument() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if len(w.t.starts) == 0 {
+		return nil
+	}
+	d := &DocumentStart{w: w}
+	for _, f := range w.t.starts {
+		if w.callDocumentStart(f, d) == Stop {
+			w.fail(ErrStopped)
+		}
+		if w.stopping() {
+			return w.cause
+		}
+	}
+	d.w = nil
+	if len(d.content) > 0 {
+		w.writeOutput(d.content)
+	}
+	return w.cause
+}
+
+// callDocumentStart calls f, recovering a panic as the callbacks of other handlers do.
+func (w *Writer) callDocumentStart(f DocumentStartHandlerFunc, d *DocumentStart) (directive RewriterDirective) {
+	defer w.recoverPanic(&directive)
+	return f(d)
+}
@@ -0,0 +1,78 @@
+package lolhtml_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestDocumentStart_Prepend(t *testing.T) {
+	hasDoctype := false
+	output, err := lolhtml.RewriteString("<p>Hello</p>", &lolhtml.Handlers{
+		DocumentContentHandler: []lolhtml.DocumentContentHandler{
+			{
+				DocumentStartHandler: func(d *lolhtml.DocumentStart) lolhtml.RewriterDirective {
+					if err := d.PrependAsHTML("<!DOCTYPE html>"); err != nil {
+						t.Error(err)
+					}
+					return lolhtml.Continue
+				},
+				DoctypeHandler: func(d *lolhtml.Doctype) lolhtml.RewriterDirective {
+					hasDoctype = true
+					return lolhtml.Continue
+				},
+			},
+			{
+				DocumentStartHandlerE: func(d *lolhtml.DocumentStart) error {
+					return d.PrependAsText("<&>")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// content prepended by the binding is not parsed again
+	if hasDoctype {
+		t.Error("doctype handler called for prepended content")
+	}
+	if want := "<!DOCTYPE html>&lt;&amp;&gt;<p>Hello</p>"; output != want {
+		t.Errorf("got %q; want %q", output, want)
+	}
+}
+
+func TestDocumentStart_EmptyDocument(t *testing.T) {
+	output, err := lolhtml.RewriteString("", &lolhtml.Handlers{
+		DocumentContentHandler: []lolhtml.DocumentContentHandler{
+			{
+				DocumentStartHandlerE: func(d *lolhtml.DocumentStart) error {
+					return d.PrependAsHTML("<!DOCTYPE html>")
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "<!DOCTYPE html>" {
+		t.Errorf("got %q", output)
+	}
+}
+
+func TestDocumentStart_Error(t *testing.T) {
+	errBoom := errors.New("boom")
+	_, err := lolhtml.RewriteString("<p>Hello</p>", &lolhtml.Handlers{
+		DocumentContentHandler: []lolhtml.DocumentContentHandler{
+			{
+				DocumentStartHandlerE: func(d *lolhtml.DocumentStart) error {
+					return errBoom
+				},
+			},
+		},
+	})
+	var handlerErr *lolhtml.HandlerError
+	if !errors.As(err, &handlerErr) || handlerErr.Kind != lolhtml.HandlerKindDocumentStart || !errors.Is(err, errBoom) {
+		t.Errorf("got %v; want a document start handler error", err)
+	}
+}
@@ -3,21 +3,71 @@ package lolhtml
 /*
 #include <stdlib.h>
 #include "lol_html.h"
+extern lol_html_rewriter_directive_t callback_end_tag(lol_html_end_tag_t *end_tag, void *user_data);
 */
 import "C"
 import (
-	"errors"
+	"context"
+	"runtime"
 	"unsafe"
 )
 
-// Element represents an HTML element.
+// Element represents an HTML element. An Element is only valid until its handler returns, use
+// Snapshot to keep its state. Building with the lolhtml_debug tag makes later use of an Element,
+// or any other content passed to handlers, panic with a clear message.
 type Element C.lol_html_element_t
 
 // ElementHandlerFunc is a callback handler function to do something with an Element.
 type ElementHandlerFunc func(*Element) RewriterDirective
 
+// ElementHandlerFuncE is like ElementHandlerFunc, but returns an error instead of a RewriterDirective.
+// A non-nil error stops the rewriter, and is returned by Writer.Write and Writer.Close wrapped in
+// a *HandlerError.
+type ElementHandlerFuncE func(*Element) error
+
+// adapt returns a ElementHandlerFunc recording the error returned by f as a *HandlerError.
+func (f ElementHandlerFuncE) adapt(selector string) ElementHandlerFunc {
+	return func(e *Element) RewriterDirective {
+		return handlerResult(HandlerKindElement, selector, f(e))
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (e *Element) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (e *Element) State() interface{} {
+	return currentState()
+}
+
+// SourceLocation returns the location of the element's start tag in the input.
+func (e *Element) SourceLocation() SourceLocation {
+	checkHandle(unsafe.Pointer(e), "element")
+	return newSourceLocation(C.lol_html_element_source_location_bytes((*C.lol_html_element_t)(e)))
+}
+
+// SetUserData attaches v to the Element, so that other handlers called with the same Element can
+// retrieve it with UserData. v is kept alive until the Writer is closed or reset.
+// It is only valid to call SetUserData inside handlers.
+func (e *Element) SetUserData(v interface{}) {
+	checkHandle(unsafe.Pointer(e), "element")
+	C.lol_html_element_user_data_set((*C.lol_html_element_t)(e), saveUserData(v))
+}
+
+// UserData returns the value attached to the Element by SetUserData, or nil if there is none.
+func (e *Element) UserData() interface{} {
+	checkHandle(unsafe.Pointer(e), "element")
+	return restorePointer(C.lol_html_element_user_data_get((*C.lol_html_element_t)(e)))
+}
+
 // TagName gets the element's tag name.
 func (e *Element) TagName() string {
+	checkHandle(unsafe.Pointer(e), "element")
 	tagNameC := (str)(C.lol_html_element_tag_name_get((*C.lol_html_element_t)(e)))
 	defer tagNameC.Free()
 	return tagNameC.String()
@@ -25,6 +75,9 @@ func (e *Element) TagName() string {
 
 // SetTagName sets the element's tag name.
 func (e *Element) SetTagName(name string) error {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	nameC := C.CString(name)
 	defer C.free(unsafe.Pointer(nameC))
 	nameLen := len(name)
@@ -37,6 +90,7 @@ func (e *Element) SetTagName(name string) error {
 
 // NamespaceURI gets the element's namespace URI.
 func (e *Element) NamespaceURI() string {
+	checkHandle(unsafe.Pointer(e), "element")
 	// don't need to be freed
 	namespaceURIC := C.lol_html_element_namespace_uri_get((*C.lol_html_element_t)(e))
 	return C.GoString(namespaceURIC)
@@ -45,11 +99,15 @@ func (e *Element) NamespaceURI() string {
 // AttributeIterator returns a pointer to an AttributeIterator. Can be used to iterate
 // over all attributes of the element.
 func (e *Element) AttributeIterator() *AttributeIterator {
+	checkHandle(unsafe.Pointer(e), "element")
 	return (*AttributeIterator)(C.lol_html_attributes_iterator_get((*C.lol_html_element_t)(e)))
 }
 
 // AttributeValue returns the value of the attribute on this element.
 func (e *Element) AttributeValue(name string) (string, error) {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	nameC := C.CString(name)
 	defer C.free(unsafe.Pointer(nameC))
 	nameLen := len(name)
@@ -60,13 +118,16 @@ func (e *Element) AttributeValue(name string) (string, error) {
 	defer errC.Free()
 	errMsg := errC.String()
 	if errMsg != "" {
-		return "", errors.New(errMsg)
+		return "", newError(errMsg)
 	}
 	return valueC.String(), nil
 }
 
 // HasAttribute returns whether the element has the attribute of this name or not.
 func (e *Element) HasAttribute(name string) (bool, error) {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	nameC := C.CString(name)
 	defer C.free(unsafe.Pointer(nameC))
 	nameLen := len(name)
@@ -81,6 +142,9 @@ func (e *Element) HasAttribute(name string) (bool, error) {
 
 // SetAttribute updates or creates the attribute with name and value on the element.
 func (e *Element) SetAttribute(name string, value string) error {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	nameC := C.CString(name)
 	defer C.free(unsafe.Pointer(nameC))
 	nameLen := len(name)
@@ -102,6 +166,9 @@ func (e *Element) SetAttribute(name string, value string) error {
 
 // RemoveAttribute removes the attribute with the name from the element.
 func (e *Element) RemoveAttribute(name string) error {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	nameC := C.CString(name)
 	defer C.free(unsafe.Pointer(nameC))
 	nameLen := len(name)
@@ -112,6 +179,89 @@ func (e *Element) RemoveAttribute(name string) error {
 	return getError()
 }
 
+// Lookup returns the value of the attribute with the name on the element, and whether the
+// attribute is present. Unlike AttributeValue, it tells a missing attribute from an empty one.
+func (e *Element) Lookup(name string) (string, bool) {
+	if has, err := e.HasAttribute(name); err != nil || !has {
+		return "", false
+	}
+	value, err := e.AttributeValue(name)
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// RangeAttributes calls f for each attribute of the element, in source order, until f returns
+// false. The attributes of the element must not be modified by f.
+func (e *Element) RangeAttributes(f func(name, value string) bool) {
+	ai := e.AttributeIterator()
+	defer ai.Free()
+	for a := ai.Next(); a != nil; a = ai.Next() {
+		if !f(a.Name(), a.Value()) {
+			return
+		}
+	}
+}
+
+// Attributes returns a snapshot of the attributes of the element, in source order.
+func (e *Element) Attributes() []AttributePair {
+	var attrs []AttributePair
+	e.RangeAttributes(func(name, value string) bool {
+		attrs = append(attrs, AttributePair{Name: name, Value: value})
+		return true
+	})
+	return attrs
+}
+
+// SetAttributes updates or creates the attributes on the element, in the given order.
+// It stops at the first attribute that cannot be set and returns the error.
+func (e *Element) SetAttributes(attrs ...AttributePair) error {
+	for _, a := range attrs {
+		if err := e.SetAttribute(a.Name, a.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveAttributes removes the attributes of the element for which pred returns true.
+func (e *Element) RemoveAttributes(pred func(name, value string) bool) error {
+	var names []string
+	e.RangeAttributes(func(name, value string) bool {
+		if pred(name, value) {
+			names = append(names, name)
+		}
+		return true
+	})
+	for _, name := range names {
+		if err := e.RemoveAttribute(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ElementInfo is a snapshot of an Element, see Element.Snapshot.
+type ElementInfo struct {
+	TagName      string
+	NamespaceURI string
+	Attributes   []AttributePair
+	Removed      bool
+}
+
+// Snapshot returns the current state of the element as a plain Go value. Unlike the Element
+// itself, which is only valid until the handler returns, the snapshot can be kept and used
+// anywhere.
+func (e *Element) Snapshot() ElementInfo {
+	return ElementInfo{
+		TagName:      e.TagName(),
+		NamespaceURI: e.NamespaceURI(),
+		Attributes:   e.Attributes(),
+		Removed:      e.IsRemoved(),
+	}
+}
+
 type elementAlter int
 
 const (
@@ -124,6 +274,9 @@ const (
 )
 
 func (e *Element) alter(content string, alter elementAlter, isHTML bool) error {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	contentC := C.CString(content)
 	defer C.free(unsafe.Pointer(contentC))
 	contentLen := len(content)
@@ -266,15 +419,92 @@ func (e *Element) ReplaceAsHTML(content string) error {
 
 // Remove completely removes the element.
 func (e *Element) Remove() {
+	checkHandle(unsafe.Pointer(e), "element")
 	C.lol_html_element_remove((*C.lol_html_element_t)(e))
 }
 
 // RemoveAndKeepContent removes the element but keeps the inner content.
 func (e *Element) RemoveAndKeepContent() {
+	checkHandle(unsafe.Pointer(e), "element")
 	C.lol_html_element_remove_and_keep_content((*C.lol_html_element_t)(e))
 }
 
 // IsRemoved returns whether the element is removed or not.
 func (e *Element) IsRemoved() bool {
+	checkHandle(unsafe.Pointer(e), "element")
 	return (bool)(C.lol_html_element_is_removed((*C.lol_html_element_t)(e)))
 }
+
+// matchSelector returns an ElementHandlerFunc recording selector as the matched selector before
+// calling f. The selectors of a selector list are registered separately with the same group, the
+// index of their ElementContentHandler, and f is only called for the first of them matching an
+// element, as when the list is registered as a whole. group is -1 for a single selector.
+func matchSelector(f ElementHandlerFunc, selector string, group int) ElementHandlerFunc {
+	return func(e *Element) RewriterDirective {
+		w := currentWriter()
+		if w == nil {
+			return f(e)
+		}
+		if group >= 0 {
+			start := e.SourceLocation().Start
+			if last, ok := w.matched[group]; ok && last == start {
+				return Continue
+			}
+			if w.matched == nil {
+				w.matched = make(map[int]int64)
+			}
+			w.matched[group] = start
+		}
+		w.matchedSelector = selector
+		return f(e)
+	}
+}
+
+// MatchedSelector returns the selector of the ElementContentHandler that matched the element.
+// When the handler was given a selector list, like "img[src], video[src]", it returns the first
+// selector of the list that matched the element, e.g. "video[src]".
+// It is only valid to call MatchedSelector inside element handlers.
+func (e *Element) MatchedSelector() string {
+	if w := currentWriter(); w != nil {
+		return w.matchedSelector
+	}
+	return ""
+}
+
+// OnEndTag registers f to be called when the end tag of the element is reached. Handlers
+// registered on the same element are called in the order in which they were registered.
+// Returns ErrNoEndTag if the element can't have an end tag, e.g. a void element like <br>.
+// It is only valid to call OnEndTag inside handlers.
+func (e *Element) OnEndTag(f EndTagHandlerFunc) error {
+	w := currentWriter()
+	if w == nil || !w.t.passThrough {
+		return e.onEndTag(f)
+	}
+	return w.holdPassThrough(e, f)
+}
+
+// onEndTag registers f to be called when the end tag of the element is reached, without holding
+// back Config.PassThroughWhenExhausted, for the handlers registered by Compile.
+func (e *Element) onEndTag(f EndTagHandlerFunc) error {
+	return e.addEndTagHandler(saveUserData(f))
+}
+
+// addEndTagHandler registers the callback for the end tag of the element with the given user
+// data, see callbackEndTag.
+func (e *Element) addEndTagHandler(userData unsafe.Pointer) error {
+	checkHandle(unsafe.Pointer(e), "element")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	errCode := C.lol_html_element_add_end_tag_handler(
+		(*C.lol_html_element_t)(e),
+		(*[0]byte)(C.callback_end_tag),
+		userData,
+	)
+	if errCode == 0 {
+		return nil
+	}
+	// lol_html only fails for elements without an end tag, and its message for it is not part of
+	// its API, so the error is replaced
+	_ = getError()
+	return ErrNoEndTag
+}
@@ -2,6 +2,8 @@ package lolhtml_test
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -496,3 +498,205 @@ func TestElement_StopRewriting(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestElement_HandlerError(t *testing.T) {
+	calls := 0
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandlerE: func(e *lolhtml.Element) error {
+						calls++
+						return e.SetTagName("")
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.Write([]byte("<div></div><div></div>"))
+	var handlerErr *lolhtml.HandlerError
+	if !errors.As(err, &handlerErr) {
+		t.Fatal(err)
+	}
+	if handlerErr.Selector != "div" || handlerErr.Kind != lolhtml.HandlerKindElement {
+		t.Errorf("got selector %q and kind %s", handlerErr.Selector, handlerErr.Kind)
+	}
+	if handlerErr.Err.Error() != "Tag name can't be empty." {
+		t.Error(handlerErr.Err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times after failing; want 1", calls)
+	}
+	// Close returns the error returned by Write
+	if closeErr := w.Close(); closeErr != err {
+		t.Error(closeErr)
+	}
+}
+
+func TestElement_UserData(t *testing.T) {
+	type classification struct{ remove bool }
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(
+		&buf,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if v := e.UserData(); v != nil {
+							t.Errorf("got %v; want nil", v)
+						}
+						isAd, err := e.HasAttribute("data-ad")
+						if err != nil {
+							t.Error(err)
+						}
+						e.SetUserData(&classification{remove: isAd})
+						return lolhtml.Continue
+					},
+				},
+				{
+					Selector: "*",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if c, ok := e.UserData().(*classification); ok && c.remove {
+							e.Remove()
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err = w.Write([]byte("<div>1</div><div data-ad>2</div><p>3</p>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := "<div>1</div><p>3</p>"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestElement_AttributeHelpers(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(
+		&buf,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "img",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						wantAttrs := []lolhtml.AttributePair{
+							{Name: "src", Value: "a.png"},
+							{Name: "alt", Value: ""},
+							{Name: "data-x", Value: "1"},
+							{Name: "data-y", Value: "2"},
+						}
+						attrs := e.Attributes()
+						if len(attrs) != len(wantAttrs) {
+							t.Errorf("got %v want %v", attrs, wantAttrs)
+						} else {
+							for i := range attrs {
+								if attrs[i] != wantAttrs[i] {
+									t.Errorf("got %v want %v", attrs, wantAttrs)
+								}
+							}
+						}
+						if v, ok := e.Lookup("alt"); v != "" || !ok {
+							t.Errorf("got %q, %v; want \"\", true", v, ok)
+						}
+						if v, ok := e.Lookup("title"); v != "" || ok {
+							t.Errorf("got %q, %v; want \"\", false", v, ok)
+						}
+						var names []string
+						e.RangeAttributes(func(name, value string) bool {
+							names = append(names, name)
+							return len(names) < 2
+						})
+						if len(names) != 2 || names[1] != "alt" {
+							t.Errorf("got %v", names)
+						}
+						err := e.RemoveAttributes(func(name, value string) bool {
+							return strings.HasPrefix(name, "data-")
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						err = e.SetAttributes(
+							lolhtml.AttributePair{Name: "alt", Value: "A"},
+							lolhtml.AttributePair{Name: "loading", Value: "lazy"},
+						)
+						if err != nil {
+							t.Error(err)
+						}
+						if err = e.SetAttributes(lolhtml.AttributePair{Name: ""}); err == nil {
+							t.Error("empty attribute name should not be set")
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err = w.Write([]byte(`<img src="a.png" alt="" data-x="1" data-y="2">`)); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := `<img src="a.png" alt="A" loading="lazy">`
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestElement_Snapshot(t *testing.T) {
+	var infos []lolhtml.ElementInfo
+	_, err := lolhtml.RewriteString(
+		`<div id="a"><svg class="b"></svg></div>`,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "*",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if e.TagName() == "svg" {
+							e.Remove()
+						}
+						infos = append(infos, e.Snapshot())
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d snapshots; want 2", len(infos))
+	}
+	div, svg := infos[0], infos[1]
+	if div.TagName != "div" || div.NamespaceURI != "http://www.w3.org/1999/xhtml" || div.Removed ||
+		len(div.Attributes) != 1 || div.Attributes[0] != (lolhtml.AttributePair{Name: "id", Value: "a"}) {
+		t.Errorf("got %+v", div)
+	}
+	if svg.TagName != "svg" || svg.NamespaceURI != "http://www.w3.org/2000/svg" || !svg.Removed ||
+		len(svg.Attributes) != 1 || svg.Attributes[0] != (lolhtml.AttributePair{Name: "class", Value: "b"}) {
+		t.Errorf("got %+v", svg)
+	}
+}
@@ -0,0 +1,134 @@
+package lolhtml
+
+/*
+#include <stdlib.h>
+#include "lol_html.h"
+*/
+import "C"
+import (
+	"context"
+	"runtime"
+	"unsafe"
+)
+
+// EndTag represents the end tag of an element. The only way to get an EndTag is by registering
+// an EndTagHandlerFunc with Element.OnEndTag.
+type EndTag C.lol_html_end_tag_t
+
+// EndTagHandlerFunc is a callback handler function to do something with an EndTag.
+type EndTagHandlerFunc func(*EndTag) RewriterDirective
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (t *EndTag) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (t *EndTag) State() interface{} {
+	return currentState()
+}
+
+// SourceLocation returns the location of the end tag in the input.
+func (t *EndTag) SourceLocation() SourceLocation {
+	checkHandle(unsafe.Pointer(t), "end tag")
+	return newSourceLocation(C.lol_html_end_tag_source_location_bytes((*C.lol_html_end_tag_t)(t)))
+}
+
+// Name returns the end tag's name.
+func (t *EndTag) Name() string {
+	checkHandle(unsafe.Pointer(t), "end tag")
+	nameC := (str)(C.lol_html_end_tag_name_get((*C.lol_html_end_tag_t)(t)))
+	defer nameC.Free()
+	return nameC.String()
+}
+
+// SetName sets the end tag's name.
+func (t *EndTag) SetName(name string) error {
+	checkHandle(unsafe.Pointer(t), "end tag")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	nameC := C.CString(name)
+	defer C.free(unsafe.Pointer(nameC))
+	nameLen := len(name)
+	errCode := C.lol_html_end_tag_name_set((*C.lol_html_end_tag_t)(t), nameC, C.size_t(nameLen))
+	if errCode == 0 {
+		return nil
+	}
+	return getError()
+}
+
+type endTagAlter int
+
+const (
+	endTagInsertBefore endTagAlter = iota
+	endTagInsertAfter
+)
+
+func (t *EndTag) alter(content string, alter endTagAlter, isHTML bool) error {
+	checkHandle(unsafe.Pointer(t), "end tag")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	contentC := C.CString(content)
+	defer C.free(unsafe.Pointer(contentC))
+	contentLen := len(content)
+	var errCode C.int
+	switch alter {
+	case endTagInsertBefore:
+		errCode = C.lol_html_end_tag_before((*C.lol_html_end_tag_t)(t), contentC, C.size_t(contentLen), C.bool(isHTML))
+	case endTagInsertAfter:
+		errCode = C.lol_html_end_tag_after((*C.lol_html_end_tag_t)(t), contentC, C.size_t(contentLen), C.bool(isHTML))
+	default:
+		panic("not implemented")
+	}
+	if errCode == 0 {
+		return nil
+	}
+	return getError()
+}
+
+// InsertBeforeAsText inserts the given content before the end tag.
+//
+// The rewriter will HTML-escape the content before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+func (t *EndTag) InsertBeforeAsText(content string) error {
+	return t.alter(content, endTagInsertBefore, false)
+}
+
+// InsertBeforeAsHTML inserts the given content before the end tag.
+// The content is inserted as is.
+func (t *EndTag) InsertBeforeAsHTML(content string) error {
+	return t.alter(content, endTagInsertBefore, true)
+}
+
+// InsertAfterAsText inserts the given content after the end tag.
+//
+// The rewriter will HTML-escape the content before insertion:
+//
+// `<` will be replaced with `&lt;`
+//
+// `>` will be replaced with `&gt;`
+//
+// `&` will be replaced with `&amp;`
+func (t *EndTag) InsertAfterAsText(content string) error {
+	return t.alter(content, endTagInsertAfter, false)
+}
+
+// InsertAfterAsHTML inserts the given content after the end tag.
+// The content is inserted as is.
+func (t *EndTag) InsertAfterAsHTML(content string) error {
+	return t.alter(content, endTagInsertAfter, true)
+}
+
+// Remove removes the end tag, but keeps the element's content and start tag.
+func (t *EndTag) Remove() {
+	checkHandle(unsafe.Pointer(t), "end tag")
+	C.lol_html_end_tag_remove((*C.lol_html_end_tag_t)(t))
+}
@@ -0,0 +1,114 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestEndTag_Modify(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(
+		&buf,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						err := e.OnEndTag(func(et *lolhtml.EndTag) lolhtml.RewriterDirective {
+							return lolhtml.Continue
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						err = e.OnEndTag(func(et *lolhtml.EndTag) lolhtml.RewriterDirective {
+							if name := et.Name(); name != "div" {
+								t.Errorf("got %s want div", name)
+							}
+							if err := et.SetName("section"); err != nil {
+								t.Error(err)
+							}
+							if err := et.InsertBeforeAsHTML("<!--before-->"); err != nil {
+								t.Error(err)
+							}
+							if err := et.InsertAfterAsText("<after>"); err != nil {
+								t.Error(err)
+							}
+							return lolhtml.Continue
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						err := e.OnEndTag(func(et *lolhtml.EndTag) lolhtml.RewriterDirective {
+							et.Remove()
+							return lolhtml.Continue
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+				{
+					Selector: "br",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						err := e.OnEndTag(func(et *lolhtml.EndTag) lolhtml.RewriterDirective {
+							return lolhtml.Continue
+						})
+						if !errors.Is(err, lolhtml.ErrNoEndTag) {
+							t.Errorf("got %v; want ErrNoEndTag", err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err = w.Write([]byte("<div><span>Hi</span><br></div>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := "<div><span>Hi<br><!--before--></section>&lt;after&gt;"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestEndTag_StopRewriting(t *testing.T) {
+	_, err := lolhtml.RewriteString(
+		"<div>Hi</div>",
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						err := e.OnEndTag(func(et *lolhtml.EndTag) lolhtml.RewriterDirective {
+							return lolhtml.Stop
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if !errors.Is(err, lolhtml.ErrStopped) {
+		t.Errorf("got %v; want ErrStopped", err)
+	}
+}
@@ -4,20 +4,276 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // ErrCannotGetErrorMessage indicates getting error code from lol_html, but unable to acquire the concrete
 // error message.
 var ErrCannotGetErrorMessage = errors.New("cannot get error message from underlying lol_html lib")
 
+// Errors reported by lol_html. Where lol_html reports several variants of an error, e.g. the
+// different reasons a tag name is invalid, the returned error keeps the original message and
+// matches the sentinel with errors.Is.
+var (
+	// ErrStopped indicates that a handler returned Stop.
+	ErrStopped = errors.New("The rewriter has been stopped.")
+	// ErrMemoryLimitExceeded indicates that the rewriter used more memory than
+	// MemorySettings.MaxAllowedMemoryUsage allows.
+	ErrMemoryLimitExceeded = errors.New("The memory limit has been exceeded.")
+	// ErrUnsupportedEncoding indicates that Config.Encoding is not an ASCII-compatible encoding.
+	ErrUnsupportedEncoding = errors.New("Expected ASCII-compatible encoding.")
+	// ErrUnknownEncoding indicates that Config.Encoding is not a known encoding label.
+	ErrUnknownEncoding = errors.New("Unknown character encoding has been provided.")
+	// ErrNoEndTag indicates that an end tag handler is added to an element that can't have an
+	// end tag, e.g. a void element like <br> or a self-closing element like <svg/>.
+	ErrNoEndTag = errors.New("the element has no end tag")
+	// ErrAmbiguousParsingContext indicates that the rewriter, in strict mode, encountered content
+	// whose parsing context can't be determined without a full HTML parser, e.g. a <script>
+	// element in a <select> element.
+	ErrAmbiguousParsingContext = errors.New("ambiguous parsing context")
+	// ErrTagNameInvalid indicates that a tag name passed to Element.SetTagName is invalid.
+	ErrTagNameInvalid = errors.New("invalid tag name")
+	// ErrAttributeNameInvalid indicates that an attribute name passed to an Element method is invalid.
+	ErrAttributeNameInvalid = errors.New("invalid attribute name")
+	// ErrCommentTextInvalid indicates that a text passed to Comment.SetText is invalid.
+	ErrCommentTextInvalid = errors.New("invalid comment text")
+)
+
+// libError is an error reported by lol_html that is one of several variants of a sentinel error.
+type libError struct {
+	msg  string
+	kind error
+}
+
+func (e *libError) Error() string {
+	return e.msg
+}
+
+func (e *libError) Unwrap() error {
+	return e.kind
+}
+
+// SelectorErrorKind tells why a selector can't be parsed, see SelectorError.
+type SelectorErrorKind int
+
+const (
+	// SelectorInvalid is a selector with a syntax error, or another error not classified below.
+	SelectorInvalid SelectorErrorKind = iota
+	// SelectorUnsupportedPseudoClass is a selector using a pseudo-class or pseudo-element not
+	// supported by lol_html, e.g. ":last-child".
+	SelectorUnsupportedPseudoClass
+	// SelectorUnsupportedCombinator is a selector using a combinator not supported by lol_html,
+	// e.g. the sibling combinators "+" and "~".
+	SelectorUnsupportedCombinator
+	// SelectorInvalidAttribute is a selector with an invalid attribute selector, e.g. an unknown
+	// operator like "[href!=x]".
+	SelectorInvalidAttribute
+)
+
+func (k SelectorErrorKind) String() string {
+	switch k {
+	case SelectorInvalid:
+		return "invalid selector"
+	case SelectorUnsupportedPseudoClass:
+		return "unsupported pseudo-class"
+	case SelectorUnsupportedCombinator:
+		return "unsupported combinator"
+	case SelectorInvalidAttribute:
+		return "invalid attribute selector"
+	default:
+		return "unknown"
+	}
+}
+
+// SelectorError is returned when a selector can't be parsed by lol_html.
+type SelectorError struct {
+	// Selector is the offending selector.
+	Selector string
+	// Reason is the message reported by lol_html.
+	Reason string
+	// Kind classifies the error.
+	Kind SelectorErrorKind
+	// Feature is the offending part of Selector, e.g. ":last-child", or "" if it can't be found.
+	Feature string
+	// Offset is the byte offset of Feature in Selector, or -1 if it can't be found.
+	Offset int
+}
+
+func (e *SelectorError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("invalid selector %q: %s", e.Selector, e.Reason)
+	}
+	return fmt.Sprintf("invalid selector %q: %s (%s %q at offset %d)", e.Selector, e.Reason, e.Kind, e.Feature, e.Offset)
+}
+
+// SinkError is returned by Writer.Write and Writer.Close when the output could not be written,
+// i.e. when the underlying io.Writer or the configured OutputSinkE returns an error.
+type SinkError struct {
+	Err error
+}
+
+func (e *SinkError) Error() string {
+	return "failed to write output: " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the output destination.
+func (e *SinkError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned by Writer.Write, Writer.WriteString and Writer.Close when rewriting
+// fails, and tells where in the input it happened. Its message is the message of Err, so use
+// errors.Is and errors.As to check for specific errors.
+type WriteError struct {
+	// Offset is the input byte offset of the content a handler was called with when the error is
+	// caused by a handler, e.g. by returning Stop, or else the offset of the first byte written
+	// by the failing call, which is the end of the input for Close.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerKind is the kind of content a handler is called with.
+type HandlerKind int
+
+const (
+	HandlerKindElement HandlerKind = iota
+	HandlerKindComment
+	HandlerKindTextChunk
+	HandlerKindDoctype
+	HandlerKindDocumentEnd
+	HandlerKindDocumentStart
+)
+
+func (k HandlerKind) String() string {
+	switch k {
+	case HandlerKindElement:
+		return "element"
+	case HandlerKindComment:
+		return "comment"
+	case HandlerKindTextChunk:
+		return "text chunk"
+	case HandlerKindDoctype:
+		return "doctype"
+	case HandlerKindDocumentEnd:
+		return "document end"
+	case HandlerKindDocumentStart:
+		return "document start"
+	default:
+		return "unknown"
+	}
+}
+
+// HandlerError is returned by Writer.Write and Writer.Close when an error-returning handler,
+// e.g. an ElementHandlerFuncE, returned an error.
+type HandlerError struct {
+	// Kind is the kind of the handler that failed.
+	Kind HandlerKind
+	// Selector is the selector of the ElementContentHandler the handler belongs to,
+	// or "" for document content handlers.
+	Selector string
+	// Err is the error returned by the handler.
+	Err error
+}
+
+func (e *HandlerError) Error() string {
+	if e.Selector == "" {
+		return fmt.Sprintf("%s handler: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s handler for selector %q: %v", e.Kind, e.Selector, e.Err)
+}
+
+// Unwrap returns the error returned by the handler.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerPanicError is returned by Writer.Write and Writer.Close when a handler or an output sink
+// panicked. The panic is recovered and the rewriter is stopped, instead of letting the panic unwind
+// through lol_html, which would crash the program. See Config.DisablePanicRecovery.
+type HandlerPanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *HandlerPanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
 // getError is a helper function that gets error message for the last function call.
 // You should make sure there is an error when calling this, or the function interprets
 // the NULL error message obtained as ErrCannotGetErrorMessage.
+//
+// lol_html keeps the last error in thread-local storage, and the Go scheduler may move a goroutine
+// to another OS thread between two cgo calls. So the failing call and getError must both be made
+// while the goroutine is locked to its thread with runtime.LockOSThread, otherwise the error may be
+// lost, or even be one of another goroutine.
 func getError() error {
 	errC := (*str)(C.lol_html_take_last_error())
 	defer errC.Free()
 	if errMsg := errC.String(); errMsg != "" {
-		return errors.New(errMsg)
+		return newError(errMsg)
 	}
 	return ErrCannotGetErrorMessage
 }
+
+// libErrors maps the messages of the bundled lol_html version, which have no error codes, to the
+// sentinel errors they are variants of.
+var libErrors = map[string]error{
+	"Tag name can't be empty.": ErrTagNameInvalid,
+	"The first character of the tag name should be an ASCII alphabetical character.":                    ErrTagNameInvalid,
+	"The tag name contains a character that can't be represented in the document's character encoding.": ErrTagNameInvalid,
+	"Attribute name can't be empty.": ErrAttributeNameInvalid,
+	"The attribute name contains a character that can't be represented in the document's character encoding.": ErrAttributeNameInvalid,
+	"Comment text shouldn't contain comment closing sequence (`-->`).":                                        ErrCommentTextInvalid,
+	"Comment text contains a character that can't be represented in the document's character encoding.":       ErrCommentTextInvalid,
+}
+
+// libErrorPatterns are the messages of the bundled lol_html version that quote a character or
+// a tag name, given by the text before and after the quoted part.
+var libErrorPatterns = []struct {
+	prefix, suffix string
+	kind           error
+}{
+	{"`", "` character is forbidden in the tag name", ErrTagNameInvalid},
+	{"`", "` character is forbidden in the attribute name", ErrAttributeNameInvalid},
+	{
+		"The parser has encountered a text content tag (`<",
+		">`) in the context where it is ambiguous whether this tag should be ignored or not. " +
+			"And, thus, is is unclear is consequent content should be parsed as raw text or HTML markup.",
+		ErrAmbiguousParsingContext,
+	},
+}
+
+// newError maps an error message reported by lol_html to the corresponding exported error.
+// Unknown messages, e.g. of another lol_html version, are returned as plain errors.
+func newError(msg string) error {
+	for _, err := range []error{ErrStopped, ErrMemoryLimitExceeded, ErrUnsupportedEncoding, ErrUnknownEncoding} {
+		if msg == err.Error() {
+			return err
+		}
+	}
+	if kind, ok := libErrors[msg]; ok {
+		return &libError{msg: msg, kind: kind}
+	}
+	for _, p := range libErrorPatterns {
+		if len(msg) > len(p.prefix)+len(p.suffix) && strings.HasPrefix(msg, p.prefix) && strings.HasSuffix(msg, p.suffix) {
+			return &libError{msg: msg, kind: p.kind}
+		}
+	}
+	return errors.New(msg)
+}
@@ -2,6 +2,7 @@ package lolhtml_test
 
 import (
 	"errors"
+	"sync"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -15,3 +16,168 @@ func TestNullErrorStr(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestErrors_Sentinels(t *testing.T) {
+	_, err := lolhtml.RewriteString(
+		"<div></div>",
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if err := e.SetTagName("1div"); !errors.Is(err, lolhtml.ErrTagNameInvalid) {
+							t.Error(err)
+						}
+						if err := e.SetAttribute("", "value"); !errors.Is(err, lolhtml.ErrAttributeNameInvalid) {
+							t.Error(err)
+						}
+						return lolhtml.Stop
+					},
+				},
+			},
+		},
+	)
+	if !errors.Is(err, lolhtml.ErrStopped) {
+		t.Error(err)
+	}
+
+	_, err = lolhtml.RewriteString(
+		"<!--comment-->",
+		&lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					CommentHandler: func(c *lolhtml.Comment) lolhtml.RewriterDirective {
+						if err := c.SetText("-->"); !errors.Is(err, lolhtml.ErrCommentTextInvalid) {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = lolhtml.RewriteString(
+		"<select><xmp><span>",
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if !errors.Is(err, lolhtml.ErrAmbiguousParsingContext) {
+		t.Error(err)
+	}
+}
+
+// TestErrors_Concurrent makes lol_html report different errors from many goroutines at the same
+// time, and checks that each goroutine gets its own error.
+func TestErrors_Concurrent(t *testing.T) {
+	const goroutines = 32
+	const iterations = 200
+
+	handlers := &lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				Selector: "div",
+				ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+					if err := e.SetTagName(""); err == nil || err.Error() != "Tag name can't be empty." {
+						t.Errorf("got %v; want empty tag name error", err)
+					}
+					return lolhtml.Continue
+				},
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					if _, err := lolhtml.RewriteString("<div></div>", handlers); err != nil {
+						t.Error(err)
+					}
+				case 1:
+					_, err := lolhtml.Compile(&lolhtml.Handlers{
+						ElementContentHandler: []lolhtml.ElementContentHandler{{Selector: "p:last-child"}},
+					})
+					var selectorErr *lolhtml.SelectorError
+					if !errors.As(err, &selectorErr) ||
+						selectorErr.Reason != "Unsupported pseudo-class or pseudo-element in selector." {
+						t.Errorf("got %v; want unsupported pseudo-class error", err)
+					}
+				case 2:
+					_, err := lolhtml.RewriteString("<div></div>", &lolhtml.Handlers{
+						ElementContentHandler: []lolhtml.ElementContentHandler{
+							{
+								Selector: "div",
+								ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+									return lolhtml.Stop
+								},
+							},
+						},
+					})
+					if !errors.Is(err, lolhtml.ErrStopped) {
+						t.Errorf("got %v; want ErrStopped", err)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestNewError checks the mapping of every error message of the bundled lol_html version.
+func TestNewError(t *testing.T) {
+	const ambiguous = "The parser has encountered a text content tag (`<script>`) in the context where it is " +
+		"ambiguous whether this tag should be ignored or not. And, thus, is is unclear is consequent " +
+		"content should be parsed as raw text or HTML markup."
+	for _, tc := range []struct {
+		msg  string
+		want error
+	}{
+		{"The rewriter has been stopped.", lolhtml.ErrStopped},
+		{"The memory limit has been exceeded.", lolhtml.ErrMemoryLimitExceeded},
+		{"Unknown character encoding has been provided.", lolhtml.ErrUnknownEncoding},
+		{"Expected ASCII-compatible encoding.", lolhtml.ErrUnsupportedEncoding},
+		{ambiguous, lolhtml.ErrAmbiguousParsingContext},
+		{"` ` character is forbidden in the tag name", lolhtml.ErrTagNameInvalid},
+		{"The first character of the tag name should be an ASCII alphabetical character.", lolhtml.ErrTagNameInvalid},
+		{"Tag name can't be empty.", lolhtml.ErrTagNameInvalid},
+		{"The tag name contains a character that can't be represented in the document's character encoding.", lolhtml.ErrTagNameInvalid},
+		{"`=` character is forbidden in the attribute name", lolhtml.ErrAttributeNameInvalid},
+		{"Attribute name can't be empty.", lolhtml.ErrAttributeNameInvalid},
+		{"The attribute name contains a character that can't be represented in the document's character encoding.", lolhtml.ErrAttributeNameInvalid},
+		{"Comment text shouldn't contain comment closing sequence (`-->`).", lolhtml.ErrCommentTextInvalid},
+		{"Comment text contains a character that can't be represented in the document's character encoding.", lolhtml.ErrCommentTextInvalid},
+		// messages merely mentioning a kind are not misclassified
+		{"The comment handler failed to parse the tag name.", nil},
+		{"Unsupported pseudo-class or pseudo-element in selector.", nil},
+	} {
+		err := lolhtml.NewError(tc.msg)
+		if err.Error() != tc.msg {
+			t.Errorf("%q: got message %q", tc.msg, err.Error())
+		}
+		for _, sentinel := range []error{
+			lolhtml.ErrStopped, lolhtml.ErrMemoryLimitExceeded, lolhtml.ErrUnknownEncoding,
+			lolhtml.ErrUnsupportedEncoding, lolhtml.ErrAmbiguousParsingContext,
+			lolhtml.ErrTagNameInvalid, lolhtml.ErrAttributeNameInvalid, lolhtml.ErrCommentTextInvalid,
+		} {
+			if got := errors.Is(err, sentinel); got != (sentinel == tc.want) {
+				t.Errorf("%q: errors.Is(err, %q) = %v", tc.msg, sentinel, got)
+			}
+		}
+	}
+}
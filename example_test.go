@@ -51,6 +51,37 @@ func ExampleNewWriter() {
 	// Output: Hello, <span>LOL-HTML</span>!
 }
 
+func ExampleNewReader() {
+	r, err := lolhtml.NewReader(
+		strings.NewReader("Hello, <span>World</span>!"),
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						err := e.SetInnerContentAsText("LOL-HTML")
+						if err != nil {
+							log.Fatal(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	// copy the rewritten content to stdout
+	_, err = io.Copy(os.Stdout, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Output: Hello, <span>LOL-HTML</span>!
+}
+
 func ExampleRewriteString() {
 	output, err := lolhtml.RewriteString(
 		`<div><a href="http://example.com"></a></div>`,
@@ -9,6 +9,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -158,11 +159,11 @@ func handler(w http.ResponseWriter, req *http.Request) {
 
 	// might be confusing
 	_, err = io.Copy(lolWriter, resp.Body)
-	if err != nil && err.Error() != "The rewriter has been stopped." {
+	if err != nil && !errors.Is(err, lolhtml.ErrStopped) {
 		sendError(w, http.StatusInternalServerError, err.Error(), pretty)
 		return
 	}
-	if err == nil || err.Error() != "The rewriter has been stopped." {
+	if err == nil || !errors.Is(err, lolhtml.ErrStopped) {
 		err = lolWriter.Close()
 		if err != nil {
 			sendError(w, http.StatusInternalServerError, err.Error(), pretty)
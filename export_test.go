@@ -3,4 +3,26 @@ package lolhtml
 // just export some internal functions for tests
 
 var GetError = getError
+var NewError = newError
 var NewSelector = newSelector
+var SplitSelectorList = splitSelectorList
+
+// ExtendedSelectorBase returns the selector handed to lol_html for an extended selector, or s
+// itself for a selector left to lol_html.
+func ExtendedSelectorBase(s string) (string, error) {
+	ext, err := parseExtendedSelector(s)
+	if err != nil || ext == nil {
+		return s, err
+	}
+	return ext.base, nil
+}
+
+// SavedPointers returns the number of values saved for C code.
+func SavedPointers() int {
+	n := 0
+	store.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,464 @@
+package lolhtml
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Extended selectors use features that lol_html does not support, but which can still be evaluated
+// by a streaming rewriter from the elements seen before: the structural pseudo-classes
+// :first-child, :nth-child(an+b), :first-of-type and :nth-of-type(an+b) on the last compound
+// selector, and a sibling combinator "+" or "~" right before it, like "h2 + p:first-of-type".
+// Pseudo-classes depending on content after the element, like :last-child or :has(), can't be
+// evaluated while streaming and are still rejected by lol_html.
+//
+// The rest of the selector is handed to lol_html, e.g. "ul > li" for "ul > li:nth-child(odd)",
+// and the remaining predicates are checked in Go before calling the element handler, using the
+// sibling counters kept with the open elements, see trackAncestors.
+
+// streamingPseudoClasses are the pseudo-classes evaluated in Go.
+//
+// Their sibling counters are only as accurate as the stack of open elements: elements closed
+// without an end tag are only popped by the simplified rules of implicitClose, see
+// closeImplicitly, so in markup relying on other rules of the HTML tree construction, e.g.
+// "<table><a>", an element may be counted as the child of the wrong parent.
+var streamingPseudoClasses = map[string]bool{
+	"first-child":   true,
+	"nth-child":     true,
+	"first-of-type": true,
+	"nth-of-type":   true,
+}
+
+// nth is an an+b expression of :nth-child and :nth-of-type.
+type nth struct {
+	a, b   int
+	ofType bool
+}
+
+// matches reports whether the 1-based index matches the expression.
+func (n nth) matches(index int) bool {
+	if n.a == 0 {
+		return index == n.b
+	}
+	d := index - n.b
+	return d%n.a == 0 && d/n.a >= 0
+}
+
+// compound is a compound selector made of a type selector, IDs and classes only, matched
+// against the previous siblings of an element.
+type compound struct {
+	tagName string // "" for any element
+	ids     []string
+	classes []string
+}
+
+func (c *compound) matches(a Ancestor) bool {
+	if c.tagName != "" && c.tagName != a.TagName {
+		return false
+	}
+	for _, id := range c.ids {
+		if id != a.ID {
+			return false
+		}
+	}
+	classes := strings.Fields(a.Class)
+	for _, class := range c.classes {
+		if !containsString(classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// extendedSelector is a selector with predicates evaluated in Go.
+type extendedSelector struct {
+	base       string // the selector handed to lol_html
+	nths       []nth
+	sibling    *compound // the compound selector before a sibling combinator
+	adjacent   bool      // whether the sibling combinator is "+" rather than "~"
+	hasSibling bool
+}
+
+// matches reports whether the element being handled by w matches the predicates.
+func (s *extendedSelector) matches(w *Writer) bool {
+	pos := &w.position
+	for _, n := range s.nths {
+		index := pos.index
+		if n.ofType {
+			index = pos.typeIndex
+		}
+		if !n.matches(index) {
+			return false
+		}
+	}
+	if s.hasSibling {
+		if s.adjacent {
+			return len(pos.siblings) > 0 && s.sibling.matches(pos.siblings[len(pos.siblings)-1])
+		}
+		for _, a := range pos.siblings {
+			if s.sibling.matches(a) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// selectorPart is a compound selector of a complex selector, with the combinator before it.
+type selectorPart struct {
+	combinator byte // 0 for the first part, ' ', '>', '+' or '~' otherwise
+	offset     int
+	text       string
+}
+
+// splitCompounds splits a complex selector into its compound selectors.
+func splitCompounds(s string) []selectorPart {
+	var parts []selectorPart
+	var combinator byte
+	start := -1
+	end := func(i int) {
+		if start >= 0 {
+			parts = append(parts, selectorPart{combinator: combinator, offset: start, text: s[start:i]})
+			start = -1
+			combinator = ' '
+		}
+	}
+	scanSelector(s, func(i int, c byte, brackets, parens int) bool {
+		if brackets > 0 || parens > 0 || c == ']' || c == ')' {
+			if start < 0 {
+				start = i
+			}
+			return true
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f':
+			end(i)
+		case '>', '+', '~':
+			end(i)
+			combinator = c
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+		return true
+	})
+	// a trailing string, e.g. in a[title="x"], is not passed to the scanner
+	if start >= 0 {
+		parts = append(parts, selectorPart{combinator: combinator, offset: start, text: s[start:]})
+	}
+	return parts
+}
+
+// pseudoClass is a pseudo-class of a compound selector.
+type pseudoClass struct {
+	offset int // offset in the compound selector
+	text   string
+	name   string
+	arg    string
+}
+
+// pseudoClasses returns the pseudo-classes of a compound selector, outside attribute selectors.
+func pseudoClasses(s string) []pseudoClass {
+	var pcs []pseudoClass
+	scanSelector(s, func(i int, c byte, brackets, parens int) bool {
+		if c != ':' || brackets > 0 || parens > 0 || i > 0 && s[i-1] == ':' {
+			return true
+		}
+		end := i + 1
+		for end < len(s) && (s[end] == ':' || s[end] == '-' || s[end] == '_' || isAlnum(s[end])) {
+			end++
+		}
+		pc := pseudoClass{offset: i, name: strings.ToLower(strings.TrimLeft(s[i:end], ":"))}
+		if end < len(s) && s[end] == '(' {
+			depth := 0
+			for j := end; j < len(s); j++ {
+				if s[j] == '(' {
+					depth++
+				} else if s[j] == ')' {
+					depth--
+					if depth == 0 {
+						pc.arg = strings.TrimSpace(s[end+1 : j])
+						end = j + 1
+						break
+					}
+				}
+			}
+		}
+		pc.text = s[i:end]
+		pcs = append(pcs, pc)
+		return true
+	})
+	return pcs
+}
+
+// parseNth parses the argument of :nth-child and :nth-of-type.
+func parseNth(arg string) (n nth, ok bool) {
+	arg = strings.ToLower(strings.Join(strings.Fields(arg), ""))
+	switch arg {
+	case "odd":
+		return nth{a: 2, b: 1}, true
+	case "even":
+		return nth{a: 2, b: 0}, true
+	}
+	i := strings.IndexByte(arg, 'n')
+	if i < 0 {
+		b, err := strconv.Atoi(arg)
+		return nth{b: b}, err == nil
+	}
+	switch a := arg[:i]; a {
+	case "", "+":
+		n.a = 1
+	case "-":
+		n.a = -1
+	default:
+		v, err := strconv.Atoi(a)
+		if err != nil {
+			return n, false
+		}
+		n.a = v
+	}
+	if rest := arg[i+1:]; rest != "" {
+		b, err := strconv.Atoi(rest)
+		if err != nil || rest[0] != '+' && rest[0] != '-' {
+			return n, false
+		}
+		n.b = b
+	}
+	return n, true
+}
+
+// parseCompound parses a compound selector made of a type selector, IDs and classes only.
+func parseCompound(s string) (*compound, bool) {
+	c := &compound{}
+	i := 0
+	for i < len(s) && s[i] != '#' && s[i] != '.' {
+		i++
+	}
+	if tagName := strings.ToLower(s[:i]); tagName != "*" {
+		c.tagName = tagName
+	}
+	for i < len(s) {
+		kind := s[i]
+		j := i + 1
+		for j < len(s) && s[j] != '#' && s[j] != '.' {
+			j++
+		}
+		name := s[i+1 : j]
+		if name == "" || strings.ContainsAny(name, `[]:()\"'`) {
+			return nil, false
+		}
+		if kind == '#' {
+			c.ids = append(c.ids, name)
+		} else {
+			c.classes = append(c.classes, name)
+		}
+		i = j
+	}
+	return c, c.tagName == "" || !strings.ContainsAny(c.tagName, `[]:()\"'`)
+}
+
+// parseExtendedSelector returns the extended selector for s, or nil if the last compound selector
+// of s only uses features left to lol_html. Unsupported features elsewhere in s are reported by
+// lol_html when the base selector is parsed.
+func parseExtendedSelector(s string) (*extendedSelector, error) {
+	parts := splitCompounds(s)
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	ext := &extendedSelector{}
+	extended := false
+	last := len(parts) - 1
+	for _, pc := range pseudoClasses(parts[last].text) {
+		if !streamingPseudoClasses[pc.name] {
+			continue
+		}
+		extended = true
+		var n nth
+		var ok bool
+		switch pc.name {
+		case "first-child", "first-of-type":
+			n, ok = nth{b: 1}, pc.arg == ""
+		default:
+			n, ok = parseNth(pc.arg)
+		}
+		if !ok {
+			return nil, &SelectorError{
+				Selector: s, Reason: "Invalid argument of pseudo-class.", Kind: SelectorInvalid,
+				Feature: pc.text, Offset: parts[last].offset + pc.offset,
+			}
+		}
+		n.ofType = strings.HasSuffix(pc.name, "of-type")
+		ext.nths = append(ext.nths, n)
+	}
+	if c := parts[last].combinator; last > 0 && (c == '+' || c == '~') {
+		sibling, ok := parseCompound(parts[last-1].text)
+		if !ok {
+			return nil, &SelectorError{
+				Selector: s, Reason: "Only type selectors, IDs and classes are supported before a sibling combinator.",
+				Kind: SelectorUnsupportedCombinator, Feature: parts[last-1].text, Offset: parts[last-1].offset,
+			}
+		}
+		extended = true
+		ext.sibling, ext.adjacent, ext.hasSibling = sibling, c == '+', true
+	}
+	if !extended {
+		return nil, nil
+	}
+
+	// the selector handed to lol_html: the ancestors and the subject without the predicates
+	subject := parts[last].text
+	for i := len(ext.nths) - 1; i >= 0; i-- {
+		for _, pc := range pseudoClasses(subject) {
+			if streamingPseudoClasses[pc.name] {
+				subject = subject[:pc.offset] + subject[pc.offset+len(pc.text):]
+				break
+			}
+		}
+	}
+	if subject == "" || subject[0] == ':' || subject[0] == '[' || subject[0] == '.' || subject[0] == '#' {
+		subject = "*" + subject
+	}
+	ancestors := parts[:last]
+	combinator := parts[last].combinator
+	if ext.hasSibling {
+		ancestors = parts[:last-1]
+		combinator = parts[last-1].combinator
+	}
+	var base strings.Builder
+	for _, part := range ancestors {
+		if part.combinator != 0 {
+			base.WriteString(string(part.combinator) + " ")
+		}
+		base.WriteString(part.text + " ")
+	}
+	if len(ancestors) > 0 && combinator != 0 {
+		base.WriteString(string(combinator) + " ")
+	}
+	base.WriteString(subject)
+	ext.base = strings.Replace(base.String(), "  ", " ", -1)
+	return ext, nil
+}
+
+// extendedSelectors returns the extended selectors of the element content handlers of h.
+func (h *Handlers) extendedSelectors() ([]*extendedSelector, error) {
+	if h == nil {
+		return nil, nil
+	}
+	var extended []*extendedSelector
+	for _, eh := range h.ElementContentHandler {
+		if eh.ParsedSelector != nil {
+			extended = append(extended, eh.ParsedSelector.extended()...)
+			continue
+		}
+		for _, s := range splitSelectorList(eh.Selector) {
+			ext, err := parseExtendedSelector(s)
+			if err != nil {
+				return nil, err
+			}
+			if ext != nil {
+				extended = append(extended, ext)
+			}
+		}
+	}
+	return extended, nil
+}
+
+// errExtendedContent is returned for comment and text chunk handlers given with extended
+// selectors, which can't be evaluated for them.
+func errExtendedContent(list string) error {
+	return fmt.Errorf("comment and text chunk handlers are not supported with the extended selector %q", list)
+}
+
+// baseSelectorError returns err, the error from parsing the base selector of the extended
+// selector s, reported for s rather than for the base selector.
+func baseSelectorError(s, base string, err error) error {
+	var selectorErr *SelectorError
+	if base == s || !errors.As(err, &selectorErr) {
+		return err
+	}
+	e := newSelectorError(base, selectorErr.Reason)
+	e.Selector = s
+	if e.Offset >= 0 {
+		// the parts of the base selector are copied from s
+		e.Offset = strings.Index(s, e.Feature)
+	}
+	if e.Offset < 0 {
+		e.Feature = ""
+	}
+	return e
+}
+
+// selectorMember is a selector of a selector list parsed by ParseSelector, parsed on its own when
+// the list has extended selectors.
+type selectorMember struct {
+	s      *selector
+	source string
+	ext    *extendedSelector // nil if source is left to lol_html
+}
+
+// parseSelectorMembers parses the selectors of list on their own if some of them are extended
+// selectors, or returns nil.
+func parseSelectorMembers(list string) ([]selectorMember, error) {
+	var members []selectorMember
+	extended := false
+	for _, source := range splitSelectorList(list) {
+		ext, err := parseExtendedSelector(source)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, selectorMember{source: source, ext: ext})
+		extended = extended || ext != nil
+	}
+	if !extended {
+		return nil, nil
+	}
+	for i := range members {
+		m := &members[i]
+		base := m.source
+		if m.ext != nil {
+			base = m.ext.base
+		}
+		s, err := newSelector(base)
+		if err != nil {
+			freeSelectorMembers(members)
+			return nil, baseSelectorError(m.source, base, err)
+		}
+		m.s = s
+	}
+	return members, nil
+}
+
+// extended returns the extended selectors of s.
+func (s *Selector) extended() []*extendedSelector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var extended []*extendedSelector
+	for _, m := range s.members {
+		if m.ext != nil {
+			extended = append(extended, m.ext)
+		}
+	}
+	return extended
+}
+
+func freeSelectorMembers(members []selectorMember) {
+	for _, m := range members {
+		m.s.Free()
+	}
+}
+
+// filterExtended returns an ElementHandlerFunc calling f only for the elements matching the
+// predicates of s.
+func filterExtended(f ElementHandlerFunc, s *extendedSelector) ElementHandlerFunc {
+	return func(e *Element) RewriterDirective {
+		w := currentWriter()
+		if w == nil || !s.matches(w) {
+			return Continue
+		}
+		return f(e)
+	}
+}
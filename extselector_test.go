@@ -0,0 +1,180 @@
+package lolhtml_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestExtendedSelectorBase(t *testing.T) {
+	testCases := []struct {
+		selector, base string
+	}{
+		{"div > p", "div > p"},
+		{"p:last-child", "p:last-child"},
+		{"ul > li:nth-child(odd)", "ul > li"},
+		{":first-child", "*"},
+		{"li:nth-of-type(2n+1).item", "li.item"},
+		{"li:first-child:not(.a)", "li:not(.a)"},
+		{"h1 + p", "p"},
+		{"div > h2.title ~ p:first-of-type", "div > p"},
+		{"article h1+p", "article p"},
+	}
+	for _, tc := range testCases {
+		base, err := lolhtml.ExtendedSelectorBase(tc.selector)
+		if err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		if base != tc.base {
+			t.Errorf("%s: got base %q; want %q", tc.selector, base, tc.base)
+		}
+	}
+}
+
+func TestElement_ExtendedSelectors(t *testing.T) {
+	testCases := []struct {
+		selector string
+		matched  string
+	}{
+		{"li:first-child", "a"},
+		{"li:nth-child(2n)", "b c"},
+		{"ul > :nth-child(-n+2)", "a b"},
+		{"li:nth-child(n+4):not(.x)", "d e"},
+		{"li:first-of-type", "a"},
+		{"br:first-of-type", "br"},
+		{"li:nth-of-type(3)", "c"},
+		{"li.x + li", "d"},
+		{"br ~ li", "c d e"},
+		{"h1 + p", "p1"},
+		{"h1 ~ p, li:nth-child(4)", "c p1 p2"},
+	}
+	input := `<ul><li id=a>1<li id=b>2<br id=br><li id=c class=x>3<li id=d>4</li><li id=e>5</ul>` +
+		`<h1></h1><p id=p1></p><p id=p2></p>`
+	for _, tc := range testCases {
+		var matched []string
+		output, err := lolhtml.RewriteString(input, &lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: tc.selector,
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						id, _ := e.Lookup("id")
+						matched = append(matched, id)
+						return lolhtml.Continue
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		if output != input {
+			t.Errorf("%s: got %s", tc.selector, output)
+		}
+		if got := strings.Join(matched, " "); got != tc.matched {
+			t.Errorf("%s: matched %q; want %q", tc.selector, got, tc.matched)
+		}
+
+		// selectors parsed beforehand match the same elements
+		matched = nil
+		parsed, err := lolhtml.ParseSelector(tc.selector)
+		if err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		_, err = lolhtml.RewriteString(input, &lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					ParsedSelector: parsed,
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						id, _ := e.Lookup("id")
+						matched = append(matched, id)
+						return lolhtml.Continue
+					},
+				},
+			},
+		})
+		parsed.Free()
+		if err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		if got := strings.Join(matched, " "); got != tc.matched {
+			t.Errorf("%s: parsed selector matched %q; want %q", tc.selector, got, tc.matched)
+		}
+	}
+}
+
+func TestElement_ExtendedSelectorErrors(t *testing.T) {
+	testCases := []struct {
+		selector string
+		kind     lolhtml.SelectorErrorKind
+		feature  string
+		offset   int
+	}{
+		{"li:nth-child(x)", lolhtml.SelectorInvalid, ":nth-child(x)", 2},
+		{"a[href] + p", lolhtml.SelectorUnsupportedCombinator, "a[href]", 0},
+		{"h1 + p:last-child", lolhtml.SelectorUnsupportedPseudoClass, ":last-child", 6},
+		{"a:nth-child(2):hover", lolhtml.SelectorUnsupportedPseudoClass, ":hover", 14},
+		// only the last compound selector can be extended
+		{"li:nth-child(2) > a", lolhtml.SelectorUnsupportedPseudoClass, ":nth-child(2)", 2},
+	}
+	for _, tc := range testCases {
+		_, err := lolhtml.Compile(&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: tc.selector,
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						return lolhtml.Continue
+					},
+				},
+			},
+		})
+		_, parseErr := lolhtml.ParseSelector(tc.selector)
+		for _, err := range []error{err, parseErr} {
+			var selectorErr *lolhtml.SelectorError
+			if !errors.As(err, &selectorErr) {
+				t.Errorf("%s: got %v", tc.selector, err)
+				continue
+			}
+			if selectorErr.Selector != tc.selector || selectorErr.Kind != tc.kind ||
+				selectorErr.Feature != tc.feature || selectorErr.Offset != tc.offset {
+				t.Errorf("%s: got %q, %v, %q at %d; want %v, %q at %d", tc.selector, selectorErr.Selector,
+					selectorErr.Kind, selectorErr.Feature, selectorErr.Offset, tc.kind, tc.feature, tc.offset)
+			}
+		}
+	}
+
+	_, err := lolhtml.Compile(&lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				Selector: "p:first-child",
+				TextChunkHandler: func(c *lolhtml.TextChunk) lolhtml.RewriterDirective {
+					return lolhtml.Continue
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Error("got no error for a text chunk handler with an extended selector")
+	}
+
+	parsed := lolhtml.MustParseSelector("p:first-child")
+	defer parsed.Free()
+	_, err = lolhtml.Compile(&lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				ParsedSelector: parsed,
+				CommentHandler: func(c *lolhtml.Comment) lolhtml.RewriterDirective {
+					return lolhtml.Continue
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Error("got no error for a comment handler with an extended parsed selector")
+	}
+}
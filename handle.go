@@ -0,0 +1,15 @@
+//go:build !lolhtml_debug
+// +build !lolhtml_debug
+
+package lolhtml
+
+import "unsafe"
+
+// enterHandle, exitHandle and checkHandle detect the use of content after its handler returned.
+// They do nothing unless built with the lolhtml_debug tag, see handle_debug.go.
+
+func enterHandle(unsafe.Pointer) {}
+
+func exitHandle(unsafe.Pointer) {}
+
+func checkHandle(unsafe.Pointer, string) {}
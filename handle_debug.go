@@ -0,0 +1,35 @@
+//go:build lolhtml_debug
+// +build lolhtml_debug
+
+package lolhtml
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// liveHandles holds the content being passed to handlers. Elements, comments, text chunks,
+// doctypes and document ends are only valid until their handler returns, so with the
+// lolhtml_debug build tag, using them afterwards panics instead of corrupting memory.
+//
+// Detection is best-effort: lol_html may allocate new content at the address of content that is
+// no longer valid, in which case the stale use goes unnoticed.
+var liveHandles sync.Map
+
+// enterHandle marks the content at p as valid, before its handler is called.
+func enterHandle(p unsafe.Pointer) {
+	liveHandles.Store(p, struct{}{})
+}
+
+// exitHandle marks the content at p as invalid, after its handler returned.
+func exitHandle(p unsafe.Pointer) {
+	liveHandles.Delete(p)
+}
+
+// checkHandle panics if the content at p is not valid anymore.
+func checkHandle(p unsafe.Pointer, kind string) {
+	if _, ok := liveHandles.Load(p); !ok {
+		panic(fmt.Sprintf("lolhtml: %s %p used after its handler returned", kind, p))
+	}
+}
@@ -0,0 +1,40 @@
+//go:build lolhtml_debug
+// +build lolhtml_debug
+
+package lolhtml_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestElement_UseAfterHandler(t *testing.T) {
+	var stale *lolhtml.Element
+	_, err := lolhtml.RewriteString(
+		"<div></div>",
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						stale = e
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		v := recover()
+		if msg, ok := v.(string); !ok || !strings.Contains(msg, "element") {
+			t.Errorf("got panic %v", v)
+		}
+	}()
+	stale.TagName()
+}
@@ -0,0 +1,114 @@
+package lolhtml
+
+import "fmt"
+
+// matchRange returns the range of matches of the element handler of eh that are passed to it,
+// from the first to the last, 1-based. last is 0 if there is no limit.
+func (eh *ElementContentHandler) matchRange() (first, last int, err error) {
+	switch {
+	case eh.Limit < 0:
+		return 0, 0, fmt.Errorf("negative Limit %d for selector %q", eh.Limit, eh.selector())
+	case eh.Nth < 0:
+		return 0, 0, fmt.Errorf("negative Nth %d for selector %q", eh.Nth, eh.selector())
+	case eh.Once && eh.Limit > 1:
+		return 0, 0, fmt.Errorf("both Once and Limit %d are set for selector %q", eh.Limit, eh.selector())
+	}
+	first, count := 1, eh.Limit
+	if eh.Nth > 0 {
+		first = eh.Nth
+	}
+	if eh.Once || eh.Nth > 0 && count == 0 {
+		count = 1
+	}
+	if count == 0 {
+		return first, 0, nil
+	}
+	return first, first + count - 1, nil
+}
+
+// limited reports whether the element handler of eh is only called for some of its matches.
+func (eh *ElementContentHandler) limited() bool {
+	return eh.Limit > 0 || eh.Nth > 0 || eh.Once
+}
+
+// limitMatches returns an ElementHandlerFunc calling f only for the matches first to last of the
+// i-th ElementContentHandler, see ElementContentHandler.Limit. Matches are counted per document.
+func limitMatches(f ElementHandlerFunc, i, first, last int) ElementHandlerFunc {
+	return func(e *Element) RewriterDirective {
+		w := currentWriter()
+		if w == nil {
+			return f(e)
+		}
+		n := w.matches[i] + 1
+		if last > 0 && n > last {
+			return Continue
+		}
+		if w.matches == nil {
+			w.matches = make(map[int]int)
+		}
+		w.matches[i] = n
+		if n == last {
+			w.exhausted++
+			if w.t.passThrough {
+				// elements without an end tag, e.g. <img>, can't hold the pass-through back
+				_ = w.holdPassThrough(e, nil)
+			}
+		}
+		if n < first {
+			return Continue
+		}
+		return f(e)
+	}
+}
+
+// canPassThrough reports whether the rest of the document can be copied to the output as is,
+// see Config.PassThroughWhenExhausted.
+func (w *Writer) canPassThrough() bool {
+	return w.t.passThrough && w.exhausted == w.t.limited && w.endTags == 0
+}
+
+// holdPassThrough registers f, if not nil, to be called when the end tag of e is reached, and
+// keeps the rest of the document from being passed through until then, as the content of e may
+// still be changed, e.g. removed.
+func (w *Writer) holdPassThrough(e *Element, f EndTagHandlerFunc) error {
+	err := e.onEndTag(func(t *EndTag) RewriterDirective {
+		w.endTags--
+		if f == nil {
+			return Continue
+		}
+		return f(t)
+	})
+	if err == nil {
+		w.endTags++
+	}
+	return err
+}
+
+// passThrough ends the rewriter, so that the rest of the document is copied to the output as is.
+// The document end handlers called by lol_html then only include OnNoMatch handlers, which have
+// nothing to do as exhausted handlers matched elements.
+func (w *Writer) passThrough() error {
+	err := w.check(w.rewriter.End())
+	w.flushAfterDoctype()
+	w.freeRewriter()
+	w.passing = true
+	return err
+}
+
+// exhaustible reports whether h only has limited element handlers, so that the rest of a document
+// can be passed through once all of them are exhausted.
+func (h *Handlers) exhaustible() bool {
+	if h == nil || len(h.DocumentContentHandler) > 0 || len(h.ElementContentHandler) == 0 {
+		return false
+	}
+	for i := range h.ElementContentHandler {
+		eh := &h.ElementContentHandler[i]
+		_, last, err := eh.matchRange()
+		if err != nil || last == 0 || eh.ElementHandler == nil && eh.ElementHandlerE == nil ||
+			eh.CommentHandler != nil || eh.CommentHandlerE != nil ||
+			eh.TextChunkHandler != nil || eh.TextChunkHandlerE != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestElementContentHandler_Limit(t *testing.T) {
+	var got []string
+	record := func(e *lolhtml.Element) lolhtml.RewriterDirective {
+		id, _ := e.Lookup("id")
+		got = append(got, id)
+		return lolhtml.Continue
+	}
+	tmpl, err := lolhtml.Compile(&lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{Selector: "h1", Once: true, ElementHandler: record},
+			{Selector: ".ad", Nth: 3, ElementHandler: record},
+			{Selector: "img", Limit: 2, ElementHandler: record},
+			{Selector: "p", Nth: 2, Limit: 2, ElementHandler: record},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+
+	input := `<h1 id=h1></h1><h1 id=h2></h1>` +
+		`<div class=ad id=ad1></div><div class=ad id=ad2></div><div class=ad id=ad3></div><div class=ad id=ad4></div>` +
+		`<img id=i1><img id=i2><img id=i3>` +
+		`<p id=p1></p><p id=p2></p><p id=p3></p><p id=p4></p>`
+	// matches are counted per document
+	for i := 0; i < 2; i++ {
+		got = nil
+		var buf bytes.Buffer
+		w, err := tmpl.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.WriteString(input); err != nil {
+			t.Error(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+		if buf.String() != input {
+			t.Error(buf.String())
+		}
+		if s := strings.Join(got, " "); s != "h1 ad3 i1 i2 p2 p3" {
+			t.Errorf("handlers called for %q; want %q", s, "h1 ad3 i1 i2 p2 p3")
+		}
+	}
+}
+
+func TestElementContentHandler_InvalidLimit(t *testing.T) {
+	for _, eh := range []lolhtml.ElementContentHandler{
+		{Selector: "p", Limit: -1},
+		{Selector: "p", Nth: -1},
+		{Selector: "p", Once: true, Limit: 2},
+	} {
+		if _, err := lolhtml.Compile(&lolhtml.Handlers{ElementContentHandler: []lolhtml.ElementContentHandler{eh}}); err == nil {
+			t.Errorf("got no error for %+v", eh)
+		}
+	}
+}
+
+func TestConfig_PassThroughWhenExhausted(t *testing.T) {
+	tmpl, err := lolhtml.Compile(&lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				Selector: "h1",
+				Once:     true,
+				ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+					if err := e.OnEndTag(func(t *lolhtml.EndTag) lolhtml.RewriterDirective {
+						_ = t.InsertAfterAsHTML("<hr>")
+						return lolhtml.Continue
+					}); err != nil {
+						t.Error(err)
+					}
+					return lolhtml.Continue
+				},
+			},
+		},
+	}, lolhtml.Config{
+		Encoding: "utf-8",
+		Memory: &lolhtml.MemorySettings{
+			PreallocatedParsingBufferSize: 1024,
+			MaxAllowedMemoryUsage:         1<<63 - 1,
+		},
+		Strict:                   true,
+		PassThroughWhenExhausted: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+
+	var buf bytes.Buffer
+	w, err := tmpl.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"<h1>Title", "</h1><h1>Other</h1><di"} {
+		if _, err = w.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the incomplete tag is not held back once the input is passed through
+	if !strings.HasSuffix(buf.String(), "<di") {
+		t.Errorf("got %q after the handler was exhausted", buf.String())
+	}
+	if _, err = w.WriteString("v>text</div>"); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if want := "<h1>Title</h1><hr><h1>Other</h1><div>text</div>"; buf.String() != want {
+		t.Errorf("got %q; want %q", buf.String(), want)
+	}
+}
+
+// TestConfig_PassThroughAfterEndTag checks that the rest of the document is only passed through
+// after the end tag of the last element matched by an exhausted handler.
+func TestConfig_PassThroughAfterEndTag(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(
+		&buf,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: ".ad",
+					Once:     true,
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						e.Remove()
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+		lolhtml.Config{
+			Encoding: "utf-8",
+			Memory: &lolhtml.MemorySettings{
+				PreallocatedParsingBufferSize: 1024,
+				MaxAllowedMemoryUsage:         1<<63 - 1,
+			},
+			Strict:                   true,
+			PassThroughWhenExhausted: true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"<p>1</p><div class=ad>", "<b>ad</b>", "</div><div class=ad>2</div>"} {
+		if _, err = w.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if want := "<p>1</p><div class=ad>2</div>"; buf.String() != want {
+		t.Errorf("got %q; want %q", buf.String(), want)
+	}
+}
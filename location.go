@@ -0,0 +1,18 @@
+package lolhtml
+
+/*
+#include "lol_html.h"
+*/
+import "C"
+
+// SourceLocation is the location of content in the input of a Writer, as byte offsets from the
+// start of the document. Start is the offset of the first byte of the content, and End the offset
+// right after its last byte. Content inserted by handlers has no location in the input.
+type SourceLocation struct {
+	Start int64
+	End   int64
+}
+
+func newSourceLocation(l C.lol_html_source_location_bytes_t) SourceLocation {
+	return SourceLocation{Start: int64(l.start), End: int64(l.end)}
+}
@@ -0,0 +1,45 @@
+package lolhtml
+
+// noMatch is the OnNoMatch handler of the i-th ElementContentHandler.
+type noMatch struct {
+	i int
+	f DocumentEndHandlerFunc
+}
+
+// markMatched returns an ElementHandlerFunc recording that the i-th ElementContentHandler matched
+// an element in the document before calling f, if not nil.
+func markMatched(f ElementHandlerFunc, i int) ElementHandlerFunc {
+	return func(e *Element) RewriterDirective {
+		if w := currentWriter(); w != nil {
+			if w.found == nil {
+				w.found = make(map[int]bool)
+			}
+			w.found[i] = true
+		}
+		if f == nil {
+			return Continue
+		}
+		return f(e)
+	}
+}
+
+// callNoMatch returns a DocumentEndHandlerFunc calling the OnNoMatch handlers of the
+// ElementContentHandlers which matched no element, in order. It is registered by Compile after the
+// user's DocumentContentHandlers.
+func callNoMatch(handlers []noMatch) DocumentEndHandlerFunc {
+	return func(d *DocumentEnd) RewriterDirective {
+		w := currentWriter()
+		if w == nil {
+			return Continue
+		}
+		for _, h := range handlers {
+			if w.found[h.i] {
+				continue
+			}
+			if h.f(d) == Stop {
+				return Stop
+			}
+		}
+		return Continue
+	}
+}
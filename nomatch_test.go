@@ -0,0 +1,74 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestElementContentHandler_OnNoMatch(t *testing.T) {
+	const canonical = `<link rel="canonical" href="https://example.com/">`
+	calls := 0
+	tmpl, err := lolhtml.Compile(&lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				Selector: `link[rel="canonical"]`,
+				ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+					if err := e.SetAttribute("href", "https://example.com/"); err != nil {
+						t.Error(err)
+					}
+					return lolhtml.Continue
+				},
+				OnNoMatch: func(d *lolhtml.DocumentEnd) lolhtml.RewriterDirective {
+					calls++
+					if err := d.AppendAsHTML(canonical); err != nil {
+						t.Error(err)
+					}
+					return lolhtml.Continue
+				},
+			},
+			{
+				// skipped matches still count
+				Selector: "p",
+				Nth:      2,
+				OnNoMatch: func(d *lolhtml.DocumentEnd) lolhtml.RewriterDirective {
+					t.Error("OnNoMatch called for a matching selector")
+					return lolhtml.Continue
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+
+	testCases := []struct {
+		input, output string
+		calls         int
+	}{
+		{`<p><link rel="canonical" href="/">`, `<p>` + canonical, 0},
+		{`<p>`, `<p>` + canonical, 1},
+	}
+	for _, tc := range testCases {
+		calls = 0
+		var buf bytes.Buffer
+		w, err := tmpl.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.WriteString(tc.input); err != nil {
+			t.Error(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+		if buf.String() != tc.output {
+			t.Errorf("got %q; want %q", buf.String(), tc.output)
+		}
+		if calls != tc.calls {
+			t.Errorf("OnNoMatch called %d times; want %d", calls, tc.calls)
+		}
+	}
+}
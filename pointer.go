@@ -51,6 +51,12 @@ func unrefPointer(ptr unsafe.Pointer) {
 	C.free(ptr)
 }
 
+// forgetPointer drops the value saved at ptr, but keeps ptr allocated, so that the address is not
+// handed out again while C code may still pass ptr back. ptr must still be freed with C.free.
+func forgetPointer(ptr unsafe.Pointer) {
+	store.Delete(ptr)
+}
+
 func unrefPointers(ptrs []unsafe.Pointer) {
 	for _, ptr := range ptrs {
 		unrefPointer(ptr)
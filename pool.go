@@ -0,0 +1,113 @@
+package lolhtml
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Pool is a set of Writers created from the same Template, which are recycled across documents.
+// A recycled Writer keeps its registered handlers and output sink, so getting a Writer from
+// a Pool only builds a new rewriter for the document.
+//
+// A Pool is safe for concurrent use by multiple goroutines.
+//
+// It is the caller's responsibility to call Free on the Pool when done.
+type Pool struct {
+	t    *Template
+	idle chan *Writer
+
+	mu    sync.Mutex
+	freed bool
+}
+
+// NewPool returns a new Pool creating Writers from the Template, keeping at most size idle
+// Writers for reuse. The Pool holds its own reference to the Template, so the Template can be
+// freed right after NewPool returns.
+func NewPool(t *Template, size int) (*Pool, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	return &Pool{t: t, idle: make(chan *Writer, size)}, nil
+}
+
+// Get returns a Writer from the Pool, writing to w. A new Writer is created if there is no idle
+// one in the Pool.
+//
+// The Writer should be returned to the Pool by calling Put when done, instead of calling Close.
+func (p *Pool) Get(w io.Writer) (*Writer, error) {
+	return p.GetContext(context.Background(), w)
+}
+
+// GetContext is like Get, but the returned Writer is bound to ctx as in NewWriterContext.
+func (p *Pool) GetContext(ctx context.Context, w io.Writer) (*Writer, error) {
+	select {
+	case wr := <-p.idle:
+		if err := wr.ResetContext(ctx, w); err != nil {
+			wr.pool = nil
+			wr.release()
+			return nil, err
+		}
+		return wr, nil
+	default:
+	}
+
+	wr := &Writer{t: p.t, pool: p}
+	if err := wr.init(ctx, w); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// Put closes the Writer if it is still open and returns it to the Pool.
+// The Writer must not be used after calling Put.
+//
+// Put returns the error returned by Close. Note that when the error is not nil, the content
+// written to the Writer's underlying io.Writer may be incomplete.
+func (p *Pool) Put(w *Writer) error {
+	if w == nil || w.pool != p {
+		return nil
+	}
+	err := w.Close()
+	if err == ErrReentrantCall || err == ErrConcurrentUse {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.freed {
+		select {
+		case p.idle <- w:
+			return err
+		default:
+		}
+	}
+	w.pool = nil
+	w.release()
+	return err
+}
+
+// Free frees all idle Writers in the Pool. Writers currently in use are freed when they are put
+// back. The Pool must not be used to get Writers anymore.
+// Subsequent calls to Free is a no-op.
+func (p *Pool) Free() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.freed {
+		return
+	}
+	p.freed = true
+	for {
+		select {
+		case w := <-p.idle:
+			w.pool = nil
+			w.release()
+		default:
+			p.t.release()
+			return
+		}
+	}
+}
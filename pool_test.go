@@ -0,0 +1,146 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func newSpanTemplate(t *testing.T) *lolhtml.Template {
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if err := e.SetInnerContentAsText("LOL-HTML"); err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestWriter_Reset(t *testing.T) {
+	tmpl := newSpanTemplate(t)
+	defer tmpl.Free()
+
+	var buf1, buf2 bytes.Buffer
+	w, err := tmpl.NewWriter(&buf1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// discard a half-written document
+	if _, err = w.Write([]byte("<span>Wor")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Reset(&buf2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<span>World</span>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	// reuse a closed Writer
+	buf1.Reset()
+	if err = w.Reset(&buf1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<span>Hi</span>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	wantedText := "<span>LOL-HTML</span>"
+	if finalText := buf1.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+	if finalText := buf2.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestWriter_ResetAfterTemplateFreed(t *testing.T) {
+	tmpl := newSpanTemplate(t)
+	w, err := tmpl.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	tmpl.Free()
+	if err = w.Reset(nil); err != lolhtml.ErrTemplateFreed {
+		t.Errorf("got %v; want ErrTemplateFreed", err)
+	}
+}
+
+func TestWriter_ResetAfterClose(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	// the private Template freed by Close is compiled again
+	if err = w.Reset(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.WriteString("<p>Hi</p>"); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if buf.String() != "<p>Hi</p>" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestPool_GetPut(t *testing.T) {
+	tmpl := newSpanTemplate(t)
+	pool, err := lolhtml.NewPool(tmpl, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Free()
+	defer pool.Free()
+
+	var first *lolhtml.Writer
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		w, err := pool.Get(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = w
+		} else if w != first {
+			t.Error("writer is not recycled")
+		}
+		if _, err = w.Write([]byte("Hello, <span>World</span>!")); err != nil {
+			t.Error(err)
+		}
+		if err = pool.Put(w); err != nil {
+			t.Error(err)
+		}
+		wantedText := "Hello, <span>LOL-HTML</span>!"
+		if finalText := buf.String(); finalText != wantedText {
+			t.Errorf("want %s got %s \n", wantedText, finalText)
+		}
+	}
+}
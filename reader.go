@@ -0,0 +1,165 @@
+package lolhtml
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrReaderClosed is returned when a Reader is used after it has been closed.
+var ErrReaderClosed = errors.New("the reader has already been closed")
+
+// ErrReaderSink is returned by NewReader when Sink or SinkE is set in Config, as the output of
+// the rewriter is served by the Reader.
+var ErrReaderSink = errors.New("a reader can't be created with Sink or SinkE set in config")
+
+// readChunkSize is the size of the chunks read from the source of a Reader or Writer.ReadFrom.
+const readChunkSize = 32 * 1024
+
+// Reader reads data from an underlying reader and serves the rewritten form of that data
+// (see NewReader).
+type Reader struct {
+	src   io.Reader
+	w     *Writer
+	buf   bytes.Buffer // rewritten data not read yet
+	chunk []byte
+	err   error // returned once buf is drained, io.EOF after the whole document is rewritten
+}
+
+// NewReader returns a new Reader with Handlers and an optional Config configured.
+// Reads from the returned Reader return the rewritten form of the data read from src.
+//
+// It is the caller's responsibility to call Close on the Reader when done. Closing the Reader
+// does not close src.
+//
+// The Reader serves the output of the rewriter, so NewReader returns ErrReaderSink if Sink or
+// SinkE is set in Config.
+func NewReader(src io.Reader, handlers *Handlers, config ...Config) (*Reader, error) {
+	t, err := Compile(handlers, config...)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Free()
+	return t.NewReader(src)
+}
+
+// NewReader returns a new Reader with the Template's Handlers and Config.
+// Reads from the returned Reader return the rewritten form of the data read from src.
+//
+// See the package-level NewReader for details.
+func (t *Template) NewReader(src io.Reader) (*Reader, error) {
+	if t != nil && (t.config.Sink != nil || t.config.SinkE != nil) {
+		return nil, ErrReaderSink
+	}
+	r := &Reader{src: src}
+	w, err := t.NewWriter(&r.buf)
+	if err != nil {
+		return nil, err
+	}
+	r.w = w
+	return r, nil
+}
+
+// SetState sets the per-document state of the Reader, see Writer.SetState.
+func (r *Reader) SetState(v interface{}) {
+	r.w.SetState(v)
+}
+
+// State returns the state set by SetState.
+func (r *Reader) State() interface{} {
+	return r.w.State()
+}
+
+// fill reads a chunk from src and rewrites it into buf. After src is exhausted or an error occurs,
+// r.err is set.
+func (r *Reader) fill() {
+	if r.chunk == nil {
+		r.chunk = make([]byte, readChunkSize)
+	}
+	n, err := r.src.Read(r.chunk)
+	if n > 0 {
+		if _, werr := r.w.Write(r.chunk[:n]); werr != nil {
+			r.err = werr
+			return
+		}
+	}
+	switch {
+	case err == io.EOF:
+		if err = r.w.Close(); err != nil {
+			r.err = err
+		} else {
+			r.err = io.EOF
+		}
+	case err != nil:
+		r.err = err
+	}
+}
+
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for r.buf.Len() == 0 && r.err == nil {
+		r.fill()
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	return 0, r.err
+}
+
+// WriteTo implements io.WriterTo. The rewritten data is written straight to dst, without being
+// buffered by the Reader, until src is exhausted or an error occurs.
+func (r *Reader) WriteTo(dst io.Writer) (n int64, err error) {
+	if r.buf.Len() > 0 {
+		if n, err = r.buf.WriteTo(dst); err != nil {
+			return
+		}
+	}
+	if r.err != nil {
+		if r.err == io.EOF {
+			return n, nil
+		}
+		return n, r.err
+	}
+
+	cw := &countWriter{w: dst}
+	r.w.w = cw
+	_, err = r.w.ReadFrom(r.src)
+	if err == nil {
+		err = r.w.Close()
+	}
+	r.w.w = &r.buf
+	n += cw.n
+	if err != nil {
+		r.err = err
+		return n, err
+	}
+	r.err = io.EOF
+	return n, nil
+}
+
+// Close closes the Reader, releasing the underlying rewriter, but does not close src.
+// The rest of the document is discarded if it has not been read completely.
+// Subsequent calls to Close is a no-op.
+func (r *Reader) Close() error {
+	if r == nil || r.err == ErrReaderClosed {
+		return nil
+	}
+	r.err = ErrReaderClosed
+	r.buf.Reset()
+	r.w.fail(ErrReaderClosed) // do not end the document, as it is discarded
+	if err := r.w.Close(); err != nil && err != ErrReaderClosed {
+		return err
+	}
+	return nil
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
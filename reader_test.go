@@ -0,0 +1,115 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func newSpanReader(t *testing.T, src io.Reader) *lolhtml.Reader {
+	tmpl := newSpanTemplate(t)
+	defer tmpl.Free()
+	r, err := tmpl.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReader_Read(t *testing.T) {
+	r := newSpanReader(t, iotest.OneByteReader(strings.NewReader("Hello, <span>World</span>!")))
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(iotest.HalfReader(r))
+	if err != nil {
+		t.Error(err)
+	}
+	wantedText := "Hello, <span>LOL-HTML</span>!"
+	if finalText := string(b); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("got %d, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestReader_WriteTo(t *testing.T) {
+	r := newSpanReader(t, strings.NewReader("Hello, <span>World</span>!"))
+	defer r.Close()
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r)
+	if err != nil {
+		t.Error(err)
+	}
+	wantedText := "Hello, <span>LOL-HTML</span>!"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+	if n != int64(len(wantedText)) {
+		t.Errorf("got %d bytes; want %d", n, len(wantedText))
+	}
+}
+
+func TestReader_SourceError(t *testing.T) {
+	r := newSpanReader(t, io.MultiReader(strings.NewReader("<span>"), iotest.TimeoutReader(strings.NewReader("World"))))
+	if _, err := ioutil.ReadAll(iotest.OneByteReader(r)); err != iotest.ErrTimeout {
+		t.Errorf("got %v; want ErrTimeout", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != lolhtml.ErrReaderClosed {
+		t.Errorf("got %v; want ErrReaderClosed", err)
+	}
+}
+
+func TestWriter_ReadFrom(t *testing.T) {
+	var buf bytes.Buffer
+	tmpl := newSpanTemplate(t)
+	defer tmpl.Free()
+	w, err := tmpl.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "Hello, <span>World</span>!"
+	n, err := w.ReadFrom(strings.NewReader(input))
+	if err != nil {
+		t.Error(err)
+	}
+	if n != int64(len(input)) {
+		t.Errorf("got %d bytes; want %d", n, len(input))
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := "Hello, <span>LOL-HTML</span>!"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+	if _, err = w.ReadFrom(strings.NewReader(input)); !errors.Is(err, lolhtml.ErrWriterClosed) {
+		t.Errorf("got %v; want ErrWriterClosed", err)
+	}
+}
+
+func TestNewReader_Sink(t *testing.T) {
+	memory := &lolhtml.MemorySettings{
+		PreallocatedParsingBufferSize: 1024,
+		MaxAllowedMemoryUsage:         1<<63 - 1,
+	}
+	for _, config := range []lolhtml.Config{
+		{Encoding: "utf-8", Memory: memory, Sink: func([]byte) {}},
+		{Encoding: "utf-8", Memory: memory, SinkE: func([]byte) error { return nil }},
+	} {
+		if _, err := lolhtml.NewReader(strings.NewReader(""), nil, config); err != lolhtml.ErrReaderSink {
+			t.Errorf("got %v; want ErrReaderSink", err)
+		}
+	}
+}
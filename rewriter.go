@@ -3,57 +3,95 @@ package lolhtml
 /*
 #include <stdlib.h>
 #include "lol_html.h"
+extern int rewriter_write(lol_html_rewriter_t *rewriter, const char *chunk, size_t chunk_len, void *writer);
+extern int rewriter_end(lol_html_rewriter_t *rewriter, void *writer);
 */
 import "C"
 import (
+	"runtime"
 	"unsafe"
 )
 
 // rewriter represents an actual HTML rewriter.
 // rewriterBuilder, rewriter and selector are kept private to simplify public API.
 // If you find it useful to use them publicly, please inform me.
+//
+// lol_html panics the thread on any use of a rewriter after a failed write or after it has ended,
+// so rewriter keeps track of its state and returns an error instead.
 type rewriter struct {
 	rewriter *C.lol_html_rewriter_t
-	pointers []unsafe.Pointer
-	// TODO: unrecoverable bool
+	writer   unsafe.Pointer
+	err      error // the error that made the rewriter unrecoverable
+	ended    bool
+}
+
+// usable returns an error if the rewriter can't be written to or ended anymore.
+func (r *rewriter) usable() error {
+	switch {
+	case r == nil || r.rewriter == nil || r.ended:
+		return ErrWriterClosed
+	case r.err != nil:
+		return r.err
+	default:
+		return nil
+	}
 }
 
 func (r *rewriter) Write(p []byte) (n int, err error) {
+	if err = r.usable(); err != nil {
+		return 0, err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	pLen := len(p)
 	// avoid 0-sized array
 	if pLen == 0 {
 		p = []byte("\x00")
 	}
 	pC := (*C.char)(unsafe.Pointer(&p[0]))
-	errCode := C.lol_html_rewriter_write(r.rewriter, pC, C.size_t(pLen))
+	errCode := C.rewriter_write(r.rewriter, pC, C.size_t(pLen), r.writer)
 	if errCode == 0 {
 		return pLen, nil
 	}
-	return 0, getError()
+	r.err = getError()
+	return 0, r.err
 }
 
 func (r *rewriter) WriteString(chunk string) (n int, err error) {
+	if err = r.usable(); err != nil {
+		return 0, err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	chunkC := C.CString(chunk)
 	defer C.free(unsafe.Pointer(chunkC))
 	chunkLen := len(chunk)
-	errCode := C.lol_html_rewriter_write(r.rewriter, chunkC, C.size_t(chunkLen))
+	errCode := C.rewriter_write(r.rewriter, chunkC, C.size_t(chunkLen), r.writer)
 	if errCode == 0 {
 		return chunkLen, nil
 	}
-	return 0, getError()
+	r.err = getError()
+	return 0, r.err
 }
 
 func (r *rewriter) End() error {
-	errCode := C.lol_html_rewriter_end(r.rewriter)
+	if err := r.usable(); err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	r.ended = true
+	errCode := C.rewriter_end(r.rewriter, r.writer)
 	if errCode == 0 {
 		return nil
 	}
-	return getError()
+	r.err = getError()
+	return r.err
 }
 
 func (r *rewriter) Free() {
-	if r != nil {
+	if r != nil && r.rewriter != nil {
 		C.lol_html_rewriter_free(r.rewriter)
-		unrefPointers(r.pointers)
+		r.rewriter = nil
 	}
 }
@@ -1,6 +1,7 @@
 package lolhtml_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -24,6 +25,9 @@ func TestRewriter_NonAsciiEncoding(t *testing.T) {
 	if err.Error() != "Expected ASCII-compatible encoding." {
 		t.Error(err)
 	}
+	if !errors.Is(err, lolhtml.ErrUnsupportedEncoding) {
+		t.Error(err)
+	}
 	err = w.Close()
 	if err != nil {
 		t.Error(err)
@@ -36,10 +40,7 @@ func TestRewriter_MemoryLimiting(t *testing.T) {
 		&lolhtml.Handlers{
 			ElementContentHandler: []lolhtml.ElementContentHandler{
 				{
-					"span",
-					nil,
-					nil,
-					nil,
+					Selector: "span",
 				},
 			},
 		},
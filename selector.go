@@ -5,12 +5,25 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
-import "unsafe"
+import (
+	"errors"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ErrSelectorFreed indicates that a Selector has already been freed and can no longer be used
+// in ElementContentHandlers.
+var ErrSelectorFreed = errors.New("the selector has already been freed")
 
 // selector represents a parsed CSS selector.
 type selector C.lol_html_selector_t
 
 func newSelector(cssSelector string) (*selector, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	selectorC := C.CString(cssSelector)
 	defer C.free(unsafe.Pointer(selectorC))
 	selectorLen := len(cssSelector)
@@ -18,7 +31,11 @@ func newSelector(cssSelector string) (*selector, error) {
 	if s != nil {
 		return s, nil
 	}
-	return nil, getError()
+	err := getError()
+	if err == ErrCannotGetErrorMessage {
+		return nil, err
+	}
+	return nil, newSelectorError(cssSelector, err.Error())
 }
 
 func (s *selector) Free() {
@@ -26,3 +43,247 @@ func (s *selector) Free() {
 		C.lol_html_selector_free((*C.lol_html_selector_t)(s))
 	}
 }
+
+// Selector is a parsed CSS selector. Parsing a selector once with ParseSelector validates it,
+// e.g. when loading a configuration, and the Selector can then be used by any number of
+// ElementContentHandlers, see ElementContentHandler.ParsedSelector.
+//
+// A Selector is safe for concurrent use by multiple goroutines. Call Free when the Selector is
+// no longer needed to release it early; it is otherwise released when garbage collected.
+// Templates already compiled with the Selector are not affected by Free.
+type Selector struct {
+	s       *selector
+	source  string
+	members []selectorMember // the selectors of the list, if some are extended, see parseSelectorMembers
+
+	mu    sync.Mutex
+	refs  int // the Selector itself holds one reference, every Template using it holds another
+	freed bool
+}
+
+// ParseSelector parses a CSS selector. The returned error is a *SelectorError if the selector is
+// invalid or not supported by lol_html. Extended selectors are supported as in
+// ElementContentHandler.Selector, and then only with element handlers.
+func ParseSelector(cssSelector string) (*Selector, error) {
+	members, err := parseSelectorMembers(cssSelector)
+	if err != nil {
+		return nil, err
+	}
+	var s *selector
+	if members == nil {
+		if s, err = newSelector(cssSelector); err != nil {
+			return nil, err
+		}
+	}
+	sel := &Selector{s: s, source: cssSelector, members: members, refs: 1}
+	runtime.SetFinalizer(sel, (*Selector).Free)
+	return sel, nil
+}
+
+// MustParseSelector is like ParseSelector but panics if the selector can't be parsed. It simplifies
+// the initialization of global variables holding selectors.
+func MustParseSelector(cssSelector string) *Selector {
+	sel, err := ParseSelector(cssSelector)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// String returns the source of the selector.
+func (s *Selector) String() string {
+	return s.source
+}
+
+// Free releases the Selector. Templates already compiled with the Selector are not affected,
+// but the Selector can no longer be used in ElementContentHandlers.
+// Subsequent calls to Free is a no-op.
+func (s *Selector) Free() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.freed {
+		return
+	}
+	s.freed = true
+	s.unref()
+}
+
+// acquire adds a reference to the Selector on behalf of a Template.
+func (s *Selector) acquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.freed {
+		return ErrSelectorFreed
+	}
+	s.refs++
+	return nil
+}
+
+// release drops a reference previously added by acquire.
+func (s *Selector) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unref()
+}
+
+func (s *Selector) unref() {
+	s.refs--
+	if s.refs == 0 {
+		s.s.Free()
+		s.s = nil
+		freeSelectorMembers(s.members)
+		s.members = nil
+	}
+}
+
+// splitSelectorList splits a selector list like "a[href], img[src]" into its selectors, keeping
+// commas inside attribute selectors, strings and parentheses. It does not validate the selectors.
+func splitSelectorList(list string) []string {
+	var selectors []string
+	start := 0
+	scanSelector(list, func(i int, c byte, brackets, parens int) bool {
+		if c == ',' && brackets == 0 && parens == 0 {
+			selectors = append(selectors, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+		return true
+	})
+	return append(selectors, strings.TrimSpace(list[start:]))
+}
+
+// scanSelector calls f for each byte of s outside strings and escapes, with the nesting levels of
+// attribute selectors and parentheses, until f returns false.
+func scanSelector(s string, f func(i int, c byte, brackets, parens int) bool) {
+	brackets, parens := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		default:
+			switch c {
+			case '[':
+				brackets++
+			case ']':
+				brackets--
+			case '(':
+				parens++
+			case ')':
+				parens--
+			}
+			if !f(i, c, brackets, parens) {
+				return
+			}
+		}
+	}
+}
+
+// supportedPseudoClasses are the pseudo-classes supported by the bundled lol_html. The
+// structural pseudo-classes of extended selectors are removed before selectors are handed to
+// lol_html, see streamingPseudoClasses.
+var supportedPseudoClasses = map[string]bool{
+	"not": true,
+}
+
+// attributeSelector matches the content of a valid attribute selector, between the brackets.
+var attributeSelector = regexp.MustCompile(
+	`^\s*(?:(?:[-\w]+|\*)?\|)?[-\w]+\s*(?:[~|^$*]?=\s*(?:"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|[-\w]+)\s*(?:[iIsS]\s*)?)?$`,
+)
+
+// newSelectorError classifies the error reported by lol_html for a selector, and locates its
+// offending part, which lol_html does not report.
+func newSelectorError(cssSelector, reason string) *SelectorError {
+	e := &SelectorError{Selector: cssSelector, Reason: reason, Offset: -1}
+	switch lower := strings.ToLower(reason); {
+	case strings.Contains(lower, "pseudo"):
+		e.Kind = SelectorUnsupportedPseudoClass
+		e.Offset, e.Feature = findPseudoClass(cssSelector)
+	case strings.Contains(lower, "combinator"):
+		e.Kind = SelectorUnsupportedCombinator
+		e.Offset, e.Feature = findCombinator(cssSelector)
+	case strings.Contains(lower, "attribute"):
+		e.Kind = SelectorInvalidAttribute
+		e.Offset, e.Feature = findInvalidAttribute(cssSelector)
+	}
+	return e
+}
+
+// findPseudoClass returns the first unsupported pseudo-class or pseudo-element in s, or the first
+// pseudo-class if they all look supported.
+func findPseudoClass(s string) (offset int, feature string) {
+	offset, first := -1, ""
+	scanSelector(s, func(i int, c byte, brackets, parens int) bool {
+		if c != ':' || brackets > 0 || i > 0 && s[i-1] == ':' {
+			return true
+		}
+		end := i + 1
+		if end < len(s) && s[end] == ':' {
+			end++
+		}
+		for end < len(s) && (s[end] == '-' || s[end] == '_' || isAlnum(s[end])) {
+			end++
+		}
+		name := strings.ToLower(strings.TrimLeft(s[i:end], ":"))
+		if first == "" {
+			offset, first = i, s[i:end]
+		}
+		if !supportedPseudoClasses[name] || strings.HasPrefix(s[i:], "::") {
+			offset, feature = i, s[i:end]
+			return false
+		}
+		return true
+	})
+	if feature == "" {
+		feature = first
+	}
+	return offset, feature
+}
+
+// findCombinator returns the first sibling combinator in s.
+func findCombinator(s string) (offset int, feature string) {
+	offset = -1
+	scanSelector(s, func(i int, c byte, brackets, parens int) bool {
+		if (c == '+' || c == '~') && brackets == 0 && parens == 0 {
+			offset, feature = i, string(c)
+			return false
+		}
+		return true
+	})
+	return offset, feature
+}
+
+// findInvalidAttribute returns the first invalid attribute selector in s.
+func findInvalidAttribute(s string) (offset int, feature string) {
+	offset, start := -1, -1
+	scanSelector(s, func(i int, c byte, brackets, parens int) bool {
+		switch {
+		case c == '[' && brackets == 1:
+			start = i
+		case c == ']' && brackets == 0 && start >= 0:
+			if !attributeSelector.MatchString(s[start+1 : i]) {
+				offset, feature = start, s[start:i+1]
+				return false
+			}
+			start = -1
+		}
+		return true
+	})
+	if offset < 0 && start >= 0 {
+		// unterminated attribute selector
+		offset, feature = start, s[start:]
+	}
+	return offset, feature
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -1,6 +1,8 @@
 package lolhtml_test
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -11,7 +13,169 @@ func TestNewSelector_UnsupportedSelector(t *testing.T) {
 	if s != nil || err == nil {
 		t.FailNow()
 	}
-	if err.Error() != "Unsupported pseudo-class or pseudo-element in selector." {
+	var selectorErr *lolhtml.SelectorError
+	if !errors.As(err, &selectorErr) {
+		t.Fatal(err)
+	}
+	if selectorErr.Selector != "p:last-child" {
+		t.Errorf("got selector %q; want p:last-child", selectorErr.Selector)
+	}
+	if selectorErr.Reason != "Unsupported pseudo-class or pseudo-element in selector." {
 		t.Error(err)
 	}
 }
+
+func TestElement_MatchedSelector(t *testing.T) {
+	var matched []string
+	calls := 0
+	output, err := lolhtml.RewriteString(
+		`<img src="a"><video src="b"></video><img src="c, d" alt="x"><p></p>`,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: `img[src="c, d"], video[src], img[src], img[alt]`,
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						calls++
+						matched = append(matched, e.MatchedSelector())
+						return lolhtml.Continue
+					},
+				},
+				{
+					Selector: "p",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						matched = append(matched, e.MatchedSelector())
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != `<img src="a"><video src="b"></video><img src="c, d" alt="x"><p></p>` {
+		t.Error(output)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times; want once per element", calls)
+	}
+	wantedMatched := []string{"img[src]", "video[src]", `img[src="c, d"]`, "p"}
+	if len(matched) != len(wantedMatched) {
+		t.Fatalf("got %q; want %q", matched, wantedMatched)
+	}
+	for i := range matched {
+		if matched[i] != wantedMatched[i] {
+			t.Errorf("got %q; want %q", matched, wantedMatched)
+		}
+	}
+}
+
+func TestSplitSelectorList(t *testing.T) {
+	testCases := []struct {
+		list      string
+		selectors []string
+	}{
+		{"div", []string{"div"}},
+		{" a[href] ,img[src] ", []string{"a[href]", "img[src]"}},
+		{`a[title="x, y"], a[title='\'', b']`, []string{`a[title="x, y"]`, `a[title='\'', b']`}},
+		{"p:not(.a, .b), q", []string{"p:not(.a, .b)", "q"}},
+	}
+	for _, tc := range testCases {
+		selectors := lolhtml.SplitSelectorList(tc.list)
+		if len(selectors) != len(tc.selectors) {
+			t.Errorf("got %q; want %q", selectors, tc.selectors)
+			continue
+		}
+		for i := range selectors {
+			if selectors[i] != tc.selectors[i] {
+				t.Errorf("got %q; want %q", selectors, tc.selectors)
+			}
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	s, err := lolhtml.ParseSelector("span")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "span" {
+		t.Errorf("got %s want span", s)
+	}
+	handlers := &lolhtml.Handlers{
+		ElementContentHandler: []lolhtml.ElementContentHandler{
+			{
+				ParsedSelector: s,
+				ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+					if err := e.SetInnerContentAsText(e.MatchedSelector()); err != nil {
+						t.Error(err)
+					}
+					return lolhtml.Continue
+				},
+			},
+		},
+	}
+	tmpl, err := lolhtml.Compile(handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+	s.Free()
+	s.Free()
+
+	if _, err = lolhtml.Compile(handlers); err != lolhtml.ErrSelectorFreed {
+		t.Errorf("got %v; want ErrSelectorFreed", err)
+	}
+	var buf bytes.Buffer
+	w, err := tmpl.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<span>Hi</span>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := "<span>span</span>"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestMustParseSelector(t *testing.T) {
+	defer func() {
+		var selectorErr *lolhtml.SelectorError
+		if err, ok := recover().(error); !ok || !errors.As(err, &selectorErr) {
+			t.Errorf("got panic %v", err)
+		}
+	}()
+	lolhtml.MustParseSelector("p:last-child")
+}
+
+func TestParseSelector_ErrorDetails(t *testing.T) {
+	testCases := []struct {
+		selector string
+		kind     lolhtml.SelectorErrorKind
+		feature  string
+		offset   int
+	}{
+		{"div > p:last-child", lolhtml.SelectorUnsupportedPseudoClass, ":last-child", 7},
+		{"p:not(.a) a::before", lolhtml.SelectorUnsupportedPseudoClass, "::before", 11},
+		{"h1 + p", lolhtml.SelectorUnsupportedCombinator, "+", 3},
+		{`a[title="1+1"] ~ b`, lolhtml.SelectorUnsupportedCombinator, "~", 15},
+		{"a[href] img[src!=x]", lolhtml.SelectorInvalidAttribute, "[src!=x]", 11},
+	}
+	for _, tc := range testCases {
+		_, err := lolhtml.ParseSelector(tc.selector)
+		var selectorErr *lolhtml.SelectorError
+		if !errors.As(err, &selectorErr) {
+			t.Errorf("%s: got %v", tc.selector, err)
+			continue
+		}
+		if selectorErr.Kind != tc.kind || selectorErr.Feature != tc.feature || selectorErr.Offset != tc.offset {
+			t.Errorf("%s: got %v, %q at %d; want %v, %q at %d", tc.selector,
+				selectorErr.Kind, selectorErr.Feature, selectorErr.Offset, tc.kind, tc.feature, tc.offset)
+		}
+	}
+}
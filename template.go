@@ -0,0 +1,367 @@
+package lolhtml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// ErrTemplateFreed indicates that a Template has already been freed and can no longer
+// be used to create Writers.
+var ErrTemplateFreed = errors.New("the template has already been freed")
+
+// Template is a compiled form of Handlers and Config. Selectors are parsed and handlers are
+// registered only once, when the Template is compiled, and the Template can then be used to
+// create many independent Writers. This is much cheaper than calling NewWriter for every document
+// when the same handlers are applied over and over again.
+//
+// A Template is safe for concurrent use by multiple goroutines, but the Writers created from it
+// are not. Note that the handlers in a Template are shared by all Writers created from it.
+//
+// It is the caller's responsibility to call Free on the Template when no more Writers need to be
+// created. Writers that are still open keep working after the Template is freed, and the
+// underlying resources are released after the last of them is closed.
+type Template struct {
+	rb        *rewriterBuilder
+	selectors []*selector
+	parsed    []*Selector // Selectors given by the user, released after the builder is freed
+	config    Config
+	siblings  bool // whether extended selectors need the previous siblings, see addChild
+
+	starts      []DocumentStartHandlerFunc // called by the binding, see Writer.startDocument
+	limited     int                        // number of ElementContentHandlers with a limited element handler
+	passThrough bool                       // see Config.PassThroughWhenExhausted
+
+	mu    sync.Mutex
+	refs  int // the Template itself holds one reference, every open Writer holds another
+	freed bool
+}
+
+// Compile parses the selectors in Handlers and returns a Template that can be used to create
+// Writers with Handlers and an optional Config configured.
+func Compile(handlers *Handlers, config ...Config) (*Template, error) {
+	var c Config
+	if config != nil {
+		c = config[0]
+	} else {
+		c = newDefaultConfig()
+	}
+
+	t := &Template{rb: newRewriterBuilder(), config: c, refs: 1}
+	extended, err := handlers.extendedSelectors()
+	if err != nil {
+		t.free()
+		return nil, err
+	}
+	for _, ext := range extended {
+		t.siblings = t.siblings || ext.hasSibling
+	}
+	// ancestors must be tracked before the user's element handlers are called
+	if c.TrackAncestors || len(extended) > 0 {
+		if err := t.addElementContentHandlers(ancestorsSelector, trackAncestors, nil, nil); err != nil {
+			t.free()
+			return nil, err
+		}
+	}
+	hasTextHandlers := false
+	var noMatches []noMatch
+	if handlers != nil {
+		for _, dh := range handlers.DocumentContentHandler {
+			doctype, comment, textChunk, documentEnd, err := dh.handlers()
+			if err != nil {
+				t.free()
+				return nil, err
+			}
+			start, err := dh.startHandler()
+			if err != nil {
+				t.free()
+				return nil, err
+			}
+			if start != nil {
+				t.starts = append(t.starts, start)
+			}
+			hasTextHandlers = hasTextHandlers || textChunk != nil
+			if textChunk != nil && dh.TextTypes != 0 {
+				textChunk = filterTextType(textChunk, dh.TextTypes)
+			}
+			t.rb.AddDocumentContentHandlers(doctype, comment, textChunk, documentEnd)
+		}
+		for i, eh := range handlers.ElementContentHandler {
+			element, comment, textChunk, err := eh.handlers()
+			if err != nil {
+				t.free()
+				return nil, err
+			}
+			hasTextHandlers = hasTextHandlers || textChunk != nil
+			if eh.limited() {
+				first, last, err := eh.matchRange()
+				if err != nil {
+					t.free()
+					return nil, err
+				}
+				if element != nil && last > 0 {
+					element = limitMatches(element, i, first, last)
+					t.limited++
+				}
+			}
+			if eh.OnNoMatch != nil {
+				element = markMatched(element, i)
+				noMatches = append(noMatches, noMatch{i, eh.OnNoMatch})
+			}
+			if eh.ParsedSelector != nil {
+				err = t.addParsedSelector(i, eh.ParsedSelector, eh.Selector, element, comment, textChunk)
+			} else {
+				err = t.addSelectorList(i, eh.Selector, element, comment, textChunk)
+			}
+			if err != nil {
+				t.free()
+				return nil, err
+			}
+		}
+	}
+	if noMatches != nil {
+		t.rb.AddDocumentContentHandlers(nil, nil, nil, callNoMatch(noMatches))
+	}
+	t.passThrough = c.PassThroughWhenExhausted && handlers.exhaustible()
+	// text types are only needed by text chunk handlers
+	if hasTextHandlers {
+		if err := t.addElementContentHandlers(textTypeSelector, trackTextType, nil, nil); err != nil {
+			t.free()
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// addElementContentHandlers parses the selector and registers the handlers for it.
+func (t *Template) addElementContentHandlers(
+	selector string,
+	element ElementHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+) error {
+	s, err := newSelector(selector)
+	if err != nil {
+		return err
+	}
+	t.selectors = append(t.selectors, s)
+	return t.rb.AddElementContentHandlers(s, element, comment, textChunk)
+}
+
+// addParsedSelector registers handlers for a Selector given by the user, which is kept until the
+// Template is freed.
+func (t *Template) addParsedSelector(
+	i int,
+	s *Selector,
+	source string,
+	element ElementHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+) error {
+	if source != "" {
+		return fmt.Errorf("both Selector %q and ParsedSelector %q are set", source, s)
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	t.parsed = append(t.parsed, s)
+	if s.members == nil {
+		if element != nil {
+			element = matchSelector(element, s.String(), -1)
+		}
+		return t.rb.AddElementContentHandlers(s.s, element, comment, textChunk)
+	}
+	if comment != nil || textChunk != nil {
+		return errExtendedContent(s.String())
+	}
+	if element == nil {
+		return nil
+	}
+	// a list with extended selectors is registered as in addExtendedSelectors
+	group := i
+	if len(s.members) == 1 {
+		group = -1
+	}
+	for _, m := range s.members {
+		f := matchSelector(element, m.source, group)
+		if m.ext != nil {
+			f = filterExtended(f, m.ext)
+		}
+		if err := t.rb.AddElementContentHandlers(m.s, f, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSelectorList registers the handlers of the i-th ElementContentHandler. The element handler
+// is registered for each selector of a selector list, so that it can tell which one matched, see
+// Element.MatchedSelector. Comment and text chunk handlers are registered for the whole list.
+func (t *Template) addSelectorList(
+	i int,
+	list string,
+	element ElementHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+) error {
+	selectors := splitSelectorList(list)
+	extended := make([]*extendedSelector, len(selectors))
+	hasExtended := false
+	for j, s := range selectors {
+		ext, err := parseExtendedSelector(s)
+		if err != nil {
+			return err
+		}
+		extended[j] = ext
+		hasExtended = hasExtended || ext != nil
+	}
+	if hasExtended {
+		return t.addExtendedSelectors(i, list, selectors, extended, element, comment, textChunk)
+	}
+	if element == nil || len(selectors) == 1 {
+		if element != nil {
+			element = matchSelector(element, list, -1)
+		}
+		return t.addElementContentHandlers(list, element, comment, textChunk)
+	}
+	// the whole list is parsed first, so that errors are reported as before
+	if comment != nil || textChunk != nil {
+		if err := t.addElementContentHandlers(list, nil, comment, textChunk); err != nil {
+			return err
+		}
+	} else {
+		s, err := newSelector(list)
+		if err != nil {
+			return err
+		}
+		s.Free()
+	}
+	for _, s := range selectors {
+		if err := t.addElementContentHandlers(s, matchSelector(element, s, i), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addExtendedSelectors registers the element handler of the i-th ElementContentHandler for a
+// selector list with extended selectors. Each extended selector is registered with the part of it
+// supported by lol_html, and the element handler is only called for the elements matching the
+// rest of it, see extendedSelector.
+func (t *Template) addExtendedSelectors(
+	i int,
+	list string,
+	selectors []string,
+	extended []*extendedSelector,
+	element ElementHandlerFunc,
+	comment CommentHandlerFunc,
+	textChunk TextChunkHandlerFunc,
+) error {
+	if comment != nil || textChunk != nil {
+		return errExtendedContent(list)
+	}
+	if element == nil {
+		return nil
+	}
+	group := i
+	if len(selectors) == 1 {
+		group = -1
+	}
+	for j, s := range selectors {
+		f := matchSelector(element, s, group)
+		base := s
+		if ext := extended[j]; ext != nil {
+			f = filterExtended(f, ext)
+			base = ext.base
+		}
+		if err := t.addElementContentHandlers(base, f, nil, nil); err != nil {
+			return baseSelectorError(s, base, err)
+		}
+	}
+	return nil
+}
+
+// NewWriter returns a new Writer with the Template's Handlers and Config.
+// Writes to the returned Writer are rewritten and written to w.
+//
+// See the package-level NewWriter for details.
+func (t *Template) NewWriter(w io.Writer) (*Writer, error) {
+	return t.NewWriterContext(context.Background(), w)
+}
+
+// NewWriterContext is like Template.NewWriter, but the returned Writer is bound to ctx.
+//
+// See the package-level NewWriterContext for details.
+func (t *Template) NewWriterContext(ctx context.Context, w io.Writer) (*Writer, error) {
+	wr := &Writer{t: t}
+	if err := wr.init(ctx, w); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// build builds a rewriter for the Writer saved as the pointer writer.
+func (t *Template) build(writer unsafe.Pointer) (*rewriter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rb.Build(writer, t.config)
+}
+
+// Free releases the Template. Writers already created from the Template are not affected,
+// but no more Writers can be created.
+// Subsequent calls to Free is a no-op.
+func (t *Template) Free() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.freed {
+		return
+	}
+	t.freed = true
+	t.unref()
+}
+
+// acquire adds a reference to the Template on behalf of a Writer.
+func (t *Template) acquire() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.freed {
+		return ErrTemplateFreed
+	}
+	t.refs++
+	return nil
+}
+
+// release drops a reference previously added by acquire.
+func (t *Template) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.unref()
+}
+
+func (t *Template) unref() {
+	t.refs--
+	if t.refs == 0 {
+		t.free()
+	}
+}
+
+// free frees the builder first, as lol_html requires, and then the selectors it used.
+func (t *Template) free() {
+	t.rb.Free()
+	t.rb = nil
+	for _, s := range t.selectors {
+		s.Free()
+	}
+	t.selectors = nil
+	for _, s := range t.parsed {
+		s.release()
+	}
+	t.parsed = nil
+}
@@ -0,0 +1,209 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+func TestTemplate_MultipleWriters(t *testing.T) {
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if err := e.SetInnerContentAsText("LOL-HTML"); err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+
+	for _, input := range []string{"Hello, <span>World</span>!", "Bye, <span>World</span>!"} {
+		var buf bytes.Buffer
+		w, err := tmpl.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(input)); err != nil {
+			t.Error(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+		wantedText := input[:len(input)-len("World</span>!")] + "LOL-HTML</span>!"
+		if finalText := buf.String(); finalText != wantedText {
+			t.Errorf("want %s got %s \n", wantedText, finalText)
+		}
+	}
+}
+
+func TestTemplate_WriterOutlivesTemplate(t *testing.T) {
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if err := e.SetTagName("span"); err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := tmpl.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Free()
+	tmpl.Free()
+
+	if _, err = tmpl.NewWriter(nil); err != lolhtml.ErrTemplateFreed {
+		t.Errorf("got %v; want ErrTemplateFreed", err)
+	}
+
+	if _, err = w.Write([]byte("<div>Hi</div>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedText := "<span>Hi</span>"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
+
+func TestTemplate_InvalidSelector(t *testing.T) {
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "p:last-child",
+				},
+			},
+		},
+	)
+	if tmpl != nil || err == nil {
+		t.FailNow()
+	}
+	var selectorErr *lolhtml.SelectorError
+	if !errors.As(err, &selectorErr) || selectorErr.Selector != "p:last-child" {
+		t.Error(err)
+	}
+}
+
+func TestTemplate_BothHandlerVariants(t *testing.T) {
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					CommentHandler: func(c *lolhtml.Comment) lolhtml.RewriterDirective {
+						return lolhtml.Continue
+					},
+					CommentHandlerE: func(c *lolhtml.Comment) error {
+						return nil
+					},
+				},
+			},
+		},
+	)
+	if tmpl != nil || err == nil {
+		t.FailNow()
+	}
+}
+
+func TestTemplate_WriterState(t *testing.T) {
+	var endStates []interface{}
+	tmpl, err := lolhtml.Compile(
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if err := e.SetInnerContentAsText(e.State().(string)); err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					DocumentEndHandler: func(d *lolhtml.DocumentEnd) lolhtml.RewriterDirective {
+						endStates = append(endStates, d.State())
+						if s, ok := d.State().(string); ok {
+							if err := d.AppendAsText("!" + s); err != nil {
+								t.Error(err)
+							}
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmpl.Free()
+
+	var buf1, buf2 bytes.Buffer
+	w1, err := tmpl.NewWriter(&buf1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1.SetState("Alice")
+	w2, err := tmpl.NewWriter(&buf2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2.SetState("Bob")
+	for _, w := range []*lolhtml.Writer{w1, w2} {
+		if _, err = w.Write([]byte("Hello, <span>World</span>")); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, w := range []*lolhtml.Writer{w1, w2} {
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	if finalText := buf1.String(); finalText != "Hello, <span>Alice</span>!Alice" {
+		t.Errorf("got %s", finalText)
+	}
+	if finalText := buf2.String(); finalText != "Hello, <span>Bob</span>!Bob" {
+		t.Errorf("got %s", finalText)
+	}
+
+	if err = w1.Reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	if w1.State() != nil {
+		t.Errorf("got state %v after Reset; want nil", w1.State())
+	}
+	if err = w1.Close(); err != nil {
+		t.Error(err)
+	}
+	if len(endStates) != 3 || endStates[0] != "Alice" || endStates[1] != "Bob" || endStates[2] != nil {
+		t.Errorf("got document end states %v; want [Alice Bob <nil>]", endStates)
+	}
+}
@@ -5,7 +5,11 @@ package lolhtml
 #include "lol_html.h"
 */
 import "C"
-import "unsafe"
+import (
+	"context"
+	"runtime"
+	"unsafe"
+)
 
 // TextChunk represents a text chunk.
 type TextChunk C.lol_html_text_chunk_t
@@ -13,14 +17,71 @@ type TextChunk C.lol_html_text_chunk_t
 // TextChunkHandlerFunc is a callback handler function to do something with a TextChunk.
 type TextChunkHandlerFunc func(*TextChunk) RewriterDirective
 
+// TextChunkHandlerFuncE is like TextChunkHandlerFunc, but returns an error instead of a RewriterDirective.
+// A non-nil error stops the rewriter, and is returned by Writer.Write and Writer.Close wrapped in
+// a *HandlerError.
+type TextChunkHandlerFuncE func(*TextChunk) error
+
+// adapt returns a TextChunkHandlerFunc recording the error returned by f as a *HandlerError.
+func (f TextChunkHandlerFuncE) adapt(selector string) TextChunkHandlerFunc {
+	return func(t *TextChunk) RewriterDirective {
+		return handlerResult(HandlerKindTextChunk, selector, f(t))
+	}
+}
+
+// Context returns the context of the Writer rewriting the document, which is
+// context.Background() unless the Writer was created with NewWriterContext.
+// It is only valid to call Context inside handlers.
+func (t *TextChunk) Context() context.Context {
+	return currentContext()
+}
+
+// State returns the state set on the Writer rewriting the document by Writer.SetState.
+// It is only valid to call State inside handlers.
+func (t *TextChunk) State() interface{} {
+	return currentState()
+}
+
+// SourceLocation returns the location of the text chunk in the input.
+func (t *TextChunk) SourceLocation() SourceLocation {
+	checkHandle(unsafe.Pointer(t), "text chunk")
+	return newSourceLocation(C.lol_html_text_chunk_source_location_bytes((*C.lol_html_text_chunk_t)(t)))
+}
+
+// SetUserData attaches v to the TextChunk, so that other handlers called with the same TextChunk can
+// retrieve it with UserData. v is kept alive until the Writer is closed or reset.
+// It is only valid to call SetUserData inside handlers.
+func (t *TextChunk) SetUserData(v interface{}) {
+	checkHandle(unsafe.Pointer(t), "text chunk")
+	C.lol_html_text_chunk_user_data_set((*C.lol_html_text_chunk_t)(t), saveUserData(v))
+}
+
+// UserData returns the value attached to the TextChunk by SetUserData, or nil if there is none.
+func (t *TextChunk) UserData() interface{} {
+	checkHandle(unsafe.Pointer(t), "text chunk")
+	return restorePointer(C.lol_html_text_chunk_user_data_get((*C.lol_html_text_chunk_t)(t)))
+}
+
 // Content returns the text chunk's content.
 func (t *TextChunk) Content() string {
+	checkHandle(unsafe.Pointer(t), "text chunk")
 	text := (textChunkContent)(C.lol_html_text_chunk_content_get((*C.lol_html_text_chunk_t)(t)))
 	return text.String()
 }
 
+// TextType returns the type of text the text chunk belongs to. It is only valid to call TextType
+// inside handlers.
+func (t *TextChunk) TextType() TextType {
+	checkHandle(unsafe.Pointer(t), "text chunk")
+	if w := currentWriter(); w != nil {
+		return w.textType
+	}
+	return TextTypeData
+}
+
 // IsLastInTextNode returns whether the text chunk is the last in the text node.
 func (t *TextChunk) IsLastInTextNode() bool {
+	checkHandle(unsafe.Pointer(t), "text chunk")
 	return (bool)(C.lol_html_text_chunk_is_last_in_text_node((*C.lol_html_text_chunk_t)(t)))
 }
 
@@ -33,6 +94,9 @@ const (
 )
 
 func (t *TextChunk) alter(content string, alter textChunkAlter, isHTML bool) error {
+	checkHandle(unsafe.Pointer(t), "text chunk")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	contentC := C.CString(content)
 	defer C.free(unsafe.Pointer(contentC))
 	contentLen := len(content)
@@ -112,10 +176,12 @@ func (t *TextChunk) ReplaceAsHTML(content string) error {
 
 // Remove removes the text chunk.
 func (t *TextChunk) Remove() {
+	checkHandle(unsafe.Pointer(t), "text chunk")
 	C.lol_html_text_chunk_remove((*C.lol_html_text_chunk_t)(t))
 }
 
 // IsRemoved returns whether the text chunk is removed or not.
 func (t *TextChunk) IsRemoved() bool {
+	checkHandle(unsafe.Pointer(t), "text chunk")
 	return (bool)(C.lol_html_text_chunk_is_removed((*C.lol_html_text_chunk_t)(t)))
 }
@@ -2,6 +2,7 @@ package lolhtml_test
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/coolspring8/go-lolhtml"
@@ -223,3 +224,60 @@ func TestTextChunk_StopRewritingWithSelector(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestTextChunk_TextType(t *testing.T) {
+	types := map[string]lolhtml.TextType{}
+	var buf bytes.Buffer
+	w, err := lolhtml.NewWriter(
+		&buf,
+		&lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					TextChunkHandler: func(c *lolhtml.TextChunk) lolhtml.RewriterDirective {
+						if content := c.Content(); content != "" {
+							types[content] = c.TextType()
+						}
+						return lolhtml.Continue
+					},
+				},
+				{
+					TextChunkHandler: func(c *lolhtml.TextChunk) lolhtml.RewriterDirective {
+						if err := c.ReplaceAsText(strings.ToUpper(c.Content())); err != nil {
+							t.Error(err)
+						}
+						return lolhtml.Continue
+					},
+					TextTypes: lolhtml.TextTypeData | lolhtml.TextTypeRCData,
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "<title>t</title><script>s</script><p>d</p><style>r</style><svg><style>f</style><![CDATA[c]]></svg>"
+	if _, err = w.Write([]byte(input)); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	wantedTypes := map[string]lolhtml.TextType{
+		"t": lolhtml.TextTypeRCData,
+		"s": lolhtml.TextTypeScriptData,
+		"d": lolhtml.TextTypeData,
+		"r": lolhtml.TextTypeRawText,
+		"f": lolhtml.TextTypeData,
+		"c": lolhtml.TextTypeData,
+	}
+	for content, wantType := range wantedTypes {
+		if types[content] != wantType {
+			t.Errorf("got %v for %q; want %v", types[content], content, wantType)
+		}
+	}
+	wantedText := "<title>T</title><script>s</script><p>D</p><style>r</style><svg><style>F</style><![CDATA[C]]></svg>"
+	if finalText := buf.String(); finalText != wantedText {
+		t.Errorf("want %s got %s \n", wantedText, finalText)
+	}
+}
@@ -0,0 +1,97 @@
+package lolhtml
+
+import "strings"
+
+// TextType is the type of text a TextChunk belongs to, which depends on the element containing it,
+// as in the tokenizer states of the HTML specification. TextTypes can be combined with | to filter
+// text chunk handlers, see DocumentContentHandler.TextTypes.
+//
+// The types mirror the text types of lol_html, except for its CDATA section type, used for the
+// text of "<![CDATA[...]]>" in <svg> and <math>. The c-api of lol_html does not report the type of
+// a text chunk, so the binding tracks it from the elements being parsed, and CDATA sections, which
+// are not elements, can't be told apart: their text is reported as TextTypeData, like the rest of
+// foreign content.
+type TextType int
+
+const (
+	// TextTypeData is the text of most elements, e.g. the text of <p> or <div>.
+	TextTypeData TextType = 1 << iota
+	// TextTypeRCData is the text of <textarea> and <title>, where character references are
+	// decoded but no tags are recognized.
+	TextTypeRCData
+	// TextTypeRawText is the text of <style>, <xmp>, <iframe>, <noembed> and <noframes>.
+	TextTypeRawText
+	// TextTypeScriptData is the text of <script>.
+	TextTypeScriptData
+	// TextTypePlainText is all the text after a <plaintext> start tag.
+	TextTypePlainText
+)
+
+func (t TextType) String() string {
+	switch t {
+	case TextTypeData:
+		return "data"
+	case TextTypeRCData:
+		return "RCDATA"
+	case TextTypeRawText:
+		return "raw text"
+	case TextTypeScriptData:
+		return "script data"
+	case TextTypePlainText:
+		return "plain text"
+	default:
+		return "unknown"
+	}
+}
+
+const htmlNamespace = "http://www.w3.org/1999/xhtml"
+
+// textTypeSelector matches the HTML elements whose text is not TextTypeData.
+const textTypeSelector = "textarea, title, style, xmp, iframe, noembed, noframes, script, plaintext"
+
+// textTypes maps the elements matched by textTypeSelector to the type of their text.
+var textTypes = map[string]TextType{
+	"textarea":  TextTypeRCData,
+	"title":     TextTypeRCData,
+	"style":     TextTypeRawText,
+	"xmp":       TextTypeRawText,
+	"iframe":    TextTypeRawText,
+	"noembed":   TextTypeRawText,
+	"noframes":  TextTypeRawText,
+	"script":    TextTypeScriptData,
+	"plaintext": TextTypePlainText,
+}
+
+// trackTextType is registered by Compile for textTypeSelector when there are text chunk handlers.
+// lol_html does not tell the type of a text chunk, so the type is switched on the Writer at the
+// start tag of these elements, and back at their end tag. Elements in foreign content, e.g. <style>
+// in <svg>, contain regular text.
+func trackTextType(e *Element) RewriterDirective {
+	w := currentWriter()
+	if w == nil || e.NamespaceURI() != htmlNamespace {
+		return Continue
+	}
+	t, ok := textTypes[strings.ToLower(e.TagName())]
+	if !ok {
+		return Continue
+	}
+	w.textType = t
+	if t != TextTypePlainText {
+		// ErrNoEndTag can't happen, as these elements are never void
+		_ = e.onEndTag(func(*EndTag) RewriterDirective {
+			w.textType = TextTypeData
+			return Continue
+		})
+	}
+	return Continue
+}
+
+// filterTextType returns a TextChunkHandlerFunc calling f only for text chunks of the given types.
+func filterTextType(f TextChunkHandlerFunc, types TextType) TextChunkHandlerFunc {
+	return func(t *TextChunk) RewriterDirective {
+		if t.TextType()&types == 0 {
+			return Continue
+		}
+		return f(t)
+	}
+}
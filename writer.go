@@ -2,133 +2,601 @@ package lolhtml
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"runtime/debug"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	// ErrWriterClosed is returned when a Writer is used after it has been closed.
+	ErrWriterClosed = errors.New("the writer has already been closed")
+	// ErrReentrantCall is returned when a Writer is used by one of its own handlers.
+	ErrReentrantCall = errors.New("the writer is used by one of its own handlers")
+	// ErrConcurrentUse is returned when a Writer is used by several goroutines at the same time.
+	// Detecting concurrent use is best-effort, a Writer is not safe for concurrent use anyway.
+	ErrConcurrentUse = errors.New("the writer is used by several goroutines at the same time")
 )
 
 // Writer takes data written to it and writes the rewritten form of that data to an
 // underlying writer (see NewWriter).
 type Writer struct {
 	w        io.Writer
+	ctx      context.Context
+	t        *Template
+	private  bool      // whether t was compiled by the package-level NewWriter, see acquireTemplate
+	handlers *Handlers // the Handlers and Config t was compiled from, if private
+	config   []Config
+	pool     *Pool
+	self     unsafe.Pointer // saved pointer to the Writer, nil when the Writer holds no resources
 	rewriter *rewriter
 	err      error
-	closed   bool
+	cause    error // the error that made the Writer stop the rewriter, see fail
+	pending  int   // bytes of output written since the last flush
+	state    interface{}
+	userData []unsafe.Pointer // values attached to the content by handlers, see saveUserData
+	textType TextType         // the type of the text being parsed, see trackTextType
+	fed      int64            // bytes of input written to the rewriter
+	readBuf  []byte           // buffer of ReadFrom, kept across calls and Resets
+	location int64            // input offset of the content of the handler that stopped the rewriter, or -1
+
+	openElements []*openElement // see trackAncestors
+	depth        int            // number of ancestors of the element being handled
+	root         openElement    // parent of the top-level elements, for their sibling counters
+	position     position       // position of the element being handled among its siblings
+
+	matches   map[int]int  // number of elements matched by each limited handler, see limitMatches
+	exhausted int          // number of limited handlers that reached their limit
+	found     map[int]bool // handlers whose selector matched an element, see markMatched
+	endTags   int          // number of end tag handlers holding back the pass-through, see holdPassThrough
+	passing   bool         // whether the rewriter has ended and input is copied to the output, see passThrough
+
+	started        bool   // whether the DocumentStart handlers have been called, see startDocument
+	afterDoctype   []byte // content inserted after the doctype, see Doctype.InsertAfterAsHTML
+	doctypeRemoved bool   // whether the doctype being handled has been removed, see Doctype.Remove
+
+	matchedSelector string        // see Element.MatchedSelector
+	matched         map[int]int64 // input offset of the last element matched by each selector list, see matchSelector
+	closed          bool
+	busy            int32 // set while a method feeding the rewriter runs, see enter
 }
 
 // NewWriter returns a new Writer with Handlers and an optional Config configured.
 // Writes to the returned Writer are rewritten and written to w.
 //
 // It is the caller's responsibility to call Close on the Writer when done.
-// Writes may be buffered and not flushed until Close. There is no Flush method,
-// so before using the content written by w, it is necessary to call Close
-// to ensure w has finished writing.
+// Writes may be buffered and not flushed until Close, so before using the content written by w,
+// it is necessary to call Close to ensure w has finished writing. See Flush for sending output
+// early, e.g. for streaming HTTP responses.
+//
+// NewWriter parses all selectors every time it is called. To create many Writers with the
+// same Handlers and Config, Compile them into a Template once and use Template.NewWriter.
 func NewWriter(w io.Writer, handlers *Handlers, config ...Config) (*Writer, error) {
-	var c Config
-	var sink OutputSink
-	if config != nil {
-		c = config[0]
-		if c.Sink != nil {
-			sink = c.Sink
-		} else if w == nil {
-			sink = func([]byte) {}
-		} else {
-			sink = func(p []byte) {
-				_, _ = w.Write(p)
-			}
+	return NewWriterContext(context.Background(), w, handlers, config...)
+}
+
+// NewWriterContext is like NewWriter, but the returned Writer stops rewriting as soon as ctx
+// is done, and returns ctx.Err() from Write and Close. The context is checked before each handler
+// is called, so rewriting is aborted promptly even during a single large Write.
+//
+// Handlers can retrieve ctx by calling the Context method of the content they are called with.
+func NewWriterContext(ctx context.Context, w io.Writer, handlers *Handlers, config ...Config) (*Writer, error) {
+	t, err := Compile(handlers, config...)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Free()
+	wr, err := t.NewWriterContext(ctx, w)
+	if err != nil {
+		return nil, err
+	}
+	wr.private = true
+	wr.handlers = handlers
+	wr.config = config
+	return wr, nil
+}
+
+// enter marks the Writer as busy until exit is called. It returns an error instead if the Writer
+// is already busy, as lol_html does not allow a rewriter to be used again before its current call
+// returns: either a handler of this Writer is using it (on this thread), or another goroutine is.
+func (w *Writer) enter() error {
+	if atomic.CompareAndSwapInt32(&w.busy, 0, 1) {
+		return nil
+	}
+	if currentWriter() == w {
+		return ErrReentrantCall
+	}
+	return ErrConcurrentUse
+}
+
+// exit marks the Writer as not busy anymore.
+func (w *Writer) exit() {
+	atomic.StoreInt32(&w.busy, 0)
+}
+
+// init acquires the resources of the Writer if it holds none, and builds a new rewriter
+// writing to dst.
+func (w *Writer) init(ctx context.Context, dst io.Writer) error {
+	if w.self == nil {
+		if err := w.acquireTemplate(); err != nil {
+			return err
 		}
+		w.self = savePointer(w)
+	}
+	r, err := w.t.build(w.self)
+	if err != nil {
+		w.release()
+		return err
+	}
+	w.w = dst
+	w.ctx = ctx
+	w.rewriter = r
+	w.err = nil
+	w.cause = nil
+	w.pending = 0
+	w.state = nil
+	w.textType = TextTypeData
+	w.fed = 0
+	w.location = -1
+	w.openElements = w.openElements[:0]
+	w.depth = 0
+	w.root = openElement{}
+	w.position = position{}
+	for i := range w.matches {
+		delete(w.matches, i)
+	}
+	w.exhausted = 0
+	for i := range w.found {
+		delete(w.found, i)
+	}
+	w.endTags = 0
+	w.passing = false
+	w.started = false
+	w.afterDoctype = nil
+	w.doctypeRemoved = false
+	w.matchedSelector = ""
+	for group := range w.matched {
+		delete(w.matched, group)
+	}
+	w.closed = false
+	return nil
+}
+
+// acquireTemplate adds a reference to the Template of the Writer. The private Template of a Writer
+// created by the package-level NewWriter is freed when the Writer is closed, so it is compiled
+// again from the same Handlers and Config if the Writer is Reset.
+func (w *Writer) acquireTemplate() error {
+	err := w.t.acquire()
+	if err != ErrTemplateFreed || !w.private {
+		return err
+	}
+	t, err := Compile(w.handlers, w.config...)
+	if err != nil {
+		return err
+	}
+	defer t.Free()
+	w.t = t
+	return t.acquire()
+}
+
+// freeRewriter frees the rewriter and the user data attached to the content it produced.
+func (w *Writer) freeRewriter() {
+	w.rewriter.Free()
+	w.rewriter = nil
+	unrefPointers(w.userData)
+	w.userData = nil
+	for _, el := range w.openElements {
+		unrefPointer(el.userData)
+	}
+	w.openElements = w.openElements[:0]
+}
+
+// saveUserData saves v for the Writer whose handler is being called, which releases it when its
+// rewriter is freed. Nothing is saved when called outside of handlers.
+func saveUserData(v interface{}) unsafe.Pointer {
+	w := currentWriter()
+	if w == nil {
+		return nil
+	}
+	ptr := savePointer(v)
+	if ptr != nil {
+		w.userData = append(w.userData, ptr)
+	}
+	return ptr
+}
+
+// release releases the resources held by a closed Writer.
+func (w *Writer) release() {
+	if w.self == nil {
+		return
+	}
+	unrefPointer(w.self)
+	w.self = nil
+	w.t.release()
+}
+
+// writeRewritten passes a chunk of output of the rewriter to writeOutput, preceded or followed
+// by the content inserted after the doctype, depending on whether the doctype was removed:
+// the chunk is the doctype itself otherwise. The doctype handlers of a doctype are all called
+// before the next chunk, so the doctype is no longer removed after it.
+func (w *Writer) writeRewritten(p []byte) {
+	removed := w.doctypeRemoved
+	w.doctypeRemoved = false
+	if w.afterDoctype == nil {
+		w.writeOutput(p)
+		return
+	}
+	after := w.afterDoctype
+	w.afterDoctype = nil
+	if removed {
+		w.writeOutput(after)
+		w.writeOutput(p)
 	} else {
-		c = newDefaultConfig()
-		if w == nil {
-			sink = func([]byte) {}
-		} else {
-			sink = func(p []byte) {
-				_, _ = w.Write(p)
-			}
+		w.writeOutput(p)
+		w.writeOutput(after)
+	}
+}
+
+// flushAfterDoctype writes the content inserted after the doctype once the rewriter has ended,
+// in case no output followed the doctype.
+func (w *Writer) flushAfterDoctype() {
+	if w.afterDoctype != nil {
+		w.writeOutput(w.afterDoctype)
+		w.afterDoctype = nil
+	}
+}
+
+// writeOutput passes a chunk of output to the configured sink, or the underlying io.Writer if
+// there is none. Output is discarded once the Writer has failed.
+func (w *Writer) writeOutput(p []byte) {
+	if w.cause != nil {
+		return
+	}
+	var err error
+	switch c := w.t.config; {
+	case c.SinkE != nil:
+		err = c.SinkE(p)
+	case c.Sink != nil:
+		c.Sink(p)
+	case w.w != nil:
+		_, err = w.w.Write(p)
+	}
+	if err != nil {
+		w.fail(&SinkError{Err: err})
+		return
+	}
+	w.pending += len(p)
+	if f := w.t.config.AutoFlush; f != nil && f.AfterBytes > 0 && w.pending >= f.AfterBytes {
+		if err = w.flush(); err != nil {
+			w.fail(err)
 		}
 	}
+}
 
-	rb := newRewriterBuilder()
-	var selectors []*selector
-	if handlers != nil {
-		for _, dh := range handlers.DocumentContentHandler {
-			rb.AddDocumentContentHandlers(
-				dh.DoctypeHandler,
-				dh.CommentHandler,
-				dh.TextChunkHandler,
-				dh.DocumentEndHandler,
-			)
+// flusher is implemented by http.ResponseWriter and other destinations that buffer data.
+type flusher interface {
+	Flush()
+}
+
+// flushErrorer is implemented by bufio.Writer and other destinations that buffer data.
+type flushErrorer interface {
+	Flush() error
+}
+
+// flush flushes the underlying io.Writer, unless output goes to a configured sink.
+func (w *Writer) flush() error {
+	w.pending = 0
+	if c := w.t.config; c.SinkE != nil || c.Sink != nil {
+		return nil
+	}
+	switch f := w.w.(type) {
+	case flushErrorer:
+		if err := f.Flush(); err != nil {
+			return &SinkError{Err: err}
 		}
-		for _, eh := range handlers.ElementContentHandler {
-			s, err := newSelector(eh.Selector)
-			if err != nil {
-				return nil, err
-			}
-			selectors = append(selectors, s)
-			rb.AddElementContentHandlers(
-				s,
-				eh.ElementHandler,
-				eh.CommentHandler,
-				eh.TextChunkHandler,
-			)
+	case flusher:
+		f.Flush()
+	}
+	return nil
+}
+
+// autoFlush flushes the underlying io.Writer after a call to Write or Close, if the FlushPolicy
+// asks for it.
+func (w *Writer) autoFlush(closing bool) error {
+	f := w.t.config.AutoFlush
+	if f == nil || w.pending == 0 || !(closing || f.AfterWrite) {
+		return nil
+	}
+	return w.flush()
+}
+
+// Flush sends all output produced so far to the underlying io.Writer, then flushes it if it has a
+// Flush method, like http.Flusher or bufio.Writer. Output is written to the underlying io.Writer
+// as soon as lol_html produces it, but lol_html holds back content it cannot rewrite yet, e.g.
+// an incomplete tag or text that handlers may still change, until more input is written.
+//
+// Flush does nothing when output goes to a Sink or SinkE set in Config.
+func (w *Writer) Flush() error {
+	if err := w.enter(); err != nil {
+		return err
+	}
+	defer w.exit()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flush(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// SetState sets the per-document state of the Writer, which its handlers can retrieve by calling
+// the State method of the content they are called with. Handlers reading their state this way
+// do not need to capture it, so the same Handlers or Template can be shared by documents
+// rewritten concurrently.
+//
+// The state is cleared by Reset.
+func (w *Writer) SetState(v interface{}) {
+	w.state = v
+}
+
+// State returns the state set by SetState.
+func (w *Writer) State() interface{} {
+	return w.state
+}
+
+// recoverPanic is deferred by callbacks to recover a panic and record it as a *HandlerPanicError,
+// so that the panic does not unwind through lol_html. d is set to Stop if not nil.
+func (w *Writer) recoverPanic(d *RewriterDirective) {
+	if w == nil || w.t.config.DisablePanicRecovery {
+		return
+	}
+	if v := recover(); v != nil {
+		w.fail(&HandlerPanicError{Value: v, Stack: debug.Stack()})
+		if d != nil {
+			*d = Stop
 		}
 	}
-	r, err := rb.Build(sink, c)
-	if err != nil {
-		return nil, err
+}
+
+// fail records the error that makes the Writer stop the rewriter at the next handler call.
+// Only the first error is kept.
+func (w *Writer) fail(err error) {
+	if w.cause == nil {
+		w.cause = err
 	}
-	rb.Free()
-	for _, s := range selectors {
-		s.Free()
+}
+
+// stopping reports whether the rewriter should be stopped by handlers, either because of a
+// previous failure or because the context is done. It can be called on a nil Writer, which never
+// stops.
+func (w *Writer) stopping() bool {
+	if w == nil {
+		return false
+	}
+	if w.cause == nil {
+		if err := w.ctx.Err(); err != nil {
+			w.fail(err)
+		}
 	}
+	return w.cause != nil
+}
 
-	return &Writer{w: w, rewriter: r}, nil
+// check returns the error recorded by fail, if any, in favor of the error returned by lol_html,
+// which is usually just "The rewriter has been stopped." then.
+func (w *Writer) check(err error) error {
+	if w.cause != nil {
+		return w.cause
+	}
+	return err
 }
 
-func (w *Writer) Write(p []byte) (n int, err error) {
-	if w.err != nil {
-		return 0, w.err
+// locatable is content with a location in the input.
+type locatable interface {
+	SourceLocation() SourceLocation
+}
+
+// finish is deferred by callbacks, while the content x is still valid, to recover a panic as
+// recoverPanic does and, if the handler stopped the rewriter, to record the input offset of x for
+// writeError. The location is only asked for then, to save a cgo call per handler. x is nil for
+// the document end, located at the end of the input.
+func (w *Writer) finish(d *RewriterDirective, x locatable) {
+	if w == nil {
+		return
 	}
-	if len(p) == 0 {
-		return 0, nil
+	if !w.t.config.DisablePanicRecovery {
+		if v := recover(); v != nil {
+			w.fail(&HandlerPanicError{Value: v, Stack: debug.Stack()})
+			*d = Stop
+		}
 	}
-	n, err = w.rewriter.Write(p)
-	if err != nil {
-		w.err = err
+	if w.location >= 0 || *d != Stop && w.cause == nil {
 		return
 	}
-	return
+	if x == nil {
+		w.location = w.fed
+	} else {
+		w.location = x.SourceLocation().Start
+	}
+}
+
+// writeError wraps an error of a call to Write in a *WriteError. The offset is the one of the
+// content of the handler that stopped the rewriter if the error comes from a handler, see finish,
+// or the offset of the first byte written by the call otherwise.
+func (w *Writer) writeError(err error) error {
+	offset := w.fed
+	if w.location >= 0 && (w.cause != nil || err == ErrStopped) {
+		offset = w.location
+	}
+	return &WriteError{Offset: offset, Err: err}
+}
+
+// Write writes p to the Writer, calling handlers as the content is parsed. Errors from rewriting
+// are returned as a *WriteError, telling where in the input the error happened.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	return w.write(len(p), func() (int, error) {
+		return w.rewriter.Write(p)
+	}, func() {
+		w.writeOutput(p)
+	})
 }
 
 // WriteString writes a string to the Writer.
 func (w *Writer) WriteString(s string) (n int, err error) {
+	return w.write(len(s), func() (int, error) {
+		return w.rewriter.WriteString(s)
+	}, func() {
+		w.writeOutput([]byte(s))
+	})
+}
+
+// write implements Write and WriteString for a chunk of input of the given size, which feed writes
+// to the rewriter, or pass copies to the output once the document is passed through.
+func (w *Writer) write(size int, feed func() (int, error), pass func()) (n int, err error) {
+	if err = w.enter(); err != nil {
+		return 0, err
+	}
+	defer w.exit()
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
 	if w.err != nil {
 		return 0, w.err
 	}
-	if len(s) == 0 {
+	if size == 0 {
 		return 0, nil
 	}
-	n, err = w.rewriter.WriteString(s)
-	if err != nil {
+	w.location = -1
+	if w.stopping() {
+		w.err = w.writeError(w.cause)
+		return 0, w.err
+	}
+	if err = w.startDocument(); err != nil {
+		w.err = w.writeError(err)
+		return 0, w.err
+	}
+	if w.passing {
+		pass()
+		n = size
+	} else {
+		n, err = feed()
+	}
+	if err = w.check(err); err != nil {
+		w.err = w.writeError(err)
+		return 0, w.err
+	}
+	w.fed += int64(n)
+	if !w.passing && w.canPassThrough() {
+		if err = w.passThrough(); err != nil {
+			w.err = w.writeError(err)
+			return 0, w.err
+		}
+	}
+	if err = w.autoFlush(false); err != nil {
 		w.err = err
-		return
+		return 0, err
 	}
 	return
 }
 
+// ReadFrom implements io.ReaderFrom. It writes the data read from src to the Writer until src
+// is exhausted or an error occurs, but does not close the Writer.
+func (w *Writer) ReadFrom(src io.Reader) (n int64, err error) {
+	if w.readBuf == nil {
+		w.readBuf = make([]byte, readChunkSize)
+	}
+	buf := w.readBuf
+	for {
+		m, rerr := src.Read(buf)
+		if m > 0 {
+			n += int64(m)
+			if _, err = w.Write(buf[:m]); err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
 // Close closes the Writer, flushing any unwritten data to the underlying io.Writer,
-// but does not close the underlying io.Writer.
+// but does not close the underlying io.Writer. Errors from rewriting the end of the document,
+// e.g. from document end handlers, are returned as a *WriteError, as in Write.
 // Subsequent calls to Close is a no-op.
 func (w *Writer) Close() error {
-	if w == nil || w.closed {
+	if w == nil {
+		return nil
+	}
+	if err := w.enter(); err != nil {
+		return err
+	}
+	defer w.exit()
+	if w.closed {
 		return nil
 	}
 	w.closed = true
+	if w.err == nil && w.stopping() {
+		w.err = w.cause
+	}
+	w.location = -1
 	if w.err == nil {
-		w.err = w.rewriter.End()
+		if err := w.startDocument(); err != nil {
+			w.err = w.writeError(err)
+		}
+	}
+	if w.err == nil && !w.passing {
+		if err := w.check(w.rewriter.End()); err != nil {
+			w.err = w.writeError(err)
+		}
+		w.flushAfterDoctype()
+	}
+	if w.err == nil {
+		w.err = w.autoFlush(true)
+	}
+	w.freeRewriter()
+	if w.pool == nil {
+		w.release()
 	}
-	w.rewriter.Free()
 	return w.err
 }
 
+// Reset discards the Writer's state and makes it equivalent to the result of its original state
+// from NewWriter or Template.NewWriter, but writing to dst instead. This permits reusing a Writer
+// rather than allocating a new one. Reset can be called on both open and closed Writers.
+//
+// A closed Writer created by Template.NewWriter no longer holds its Template, so Reset returns
+// ErrTemplateFreed if the Template has been freed in the meantime. Writers created by the
+// package-level NewWriter free their private Template when they are closed, so Reset compiles
+// the Handlers and Config again after Close; use Compile or a Pool to reuse them instead.
+//
+// The context of a Writer created by NewWriterContext is not kept, see ResetContext.
+func (w *Writer) Reset(dst io.Writer) error {
+	return w.ResetContext(context.Background(), dst)
+}
+
+// ResetContext is like Reset, but the Writer is then bound to ctx as in NewWriterContext.
+func (w *Writer) ResetContext(ctx context.Context, dst io.Writer) error {
+	if err := w.enter(); err != nil {
+		return err
+	}
+	defer w.exit()
+	if !w.closed {
+		w.freeRewriter()
+		w.closed = true
+	}
+	return w.init(ctx, dst)
+}
+
 // RewriteString rewrites the given string with the provided Handlers and Config.
 func RewriteString(s string, handlers *Handlers, config ...Config) (string, error) {
 	var buf bytes.Buffer
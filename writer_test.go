@@ -0,0 +1,429 @@
+package lolhtml_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coolspring8/go-lolhtml"
+)
+
+var errBrokenPipe = errors.New("broken pipe")
+
+// brokenWriter accepts limit bytes and then fails.
+type brokenWriter struct {
+	limit   int
+	written int
+}
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		return 0, errBrokenPipe
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestWriter_SinkError(t *testing.T) {
+	calls := 0
+	w, err := lolhtml.NewWriter(
+		&brokenWriter{limit: 5},
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						calls++
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.Write([]byte("<div>0123456789</div><div></div><div></div>"))
+	if err == nil {
+		t.FailNow()
+	}
+	var sinkErr *lolhtml.SinkError
+	if !errors.As(err, &sinkErr) || !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times after output failed; want 1", calls)
+	}
+	if err = w.Close(); !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+}
+
+func TestWriter_SinkErrorWithoutHandlers(t *testing.T) {
+	w, err := lolhtml.NewWriter(&brokenWriter{limit: 0}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<div>Hi</div>")); !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+	if err = w.Close(); !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+}
+
+func TestWriter_OutputSinkE(t *testing.T) {
+	var chunks int
+	w, err := lolhtml.NewWriter(
+		nil,
+		nil,
+		lolhtml.Config{
+			Encoding: "utf-8",
+			Memory: &lolhtml.MemorySettings{
+				PreallocatedParsingBufferSize: 1024,
+				MaxAllowedMemoryUsage:         1<<63 - 1,
+			},
+			SinkE: func(p []byte) error {
+				chunks++
+				return errBrokenPipe
+			},
+			Strict: true,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<div>Hi</div>")); !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+	if err = w.Close(); !errors.Is(err, errBrokenPipe) {
+		t.Error(err)
+	}
+	if chunks != 1 {
+		t.Errorf("sink called %d times; want 1", chunks)
+	}
+}
+
+type contextKey struct{}
+
+func TestWriter_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey{}, "request-42"))
+	defer cancel()
+	calls := 0
+	w, err := lolhtml.NewWriterContext(
+		ctx,
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if v := e.Context().Value(contextKey{}); v != "request-42" {
+							t.Errorf("got %v; want request-42", v)
+						}
+						calls++
+						cancel()
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write([]byte("<div></div><div></div>")); !errors.Is(err, context.Canceled) {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times after cancellation; want 1", calls)
+	}
+	if err = w.Close(); !errors.Is(err, context.Canceled) {
+		t.Error(err)
+	}
+}
+
+func TestWriter_ContextDefault(t *testing.T) {
+	_, err := lolhtml.RewriteString(
+		"<!--comment-->",
+		&lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					CommentHandler: func(c *lolhtml.Comment) lolhtml.RewriterDirective {
+						if ctx := c.Context(); ctx != context.Background() {
+							t.Errorf("got %v; want context.Background()", ctx)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriter_RecoverHandlerPanic(t *testing.T) {
+	calls := 0
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						calls++
+						panic("boom")
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.Write([]byte("<div></div><div></div>"))
+	var panicErr *lolhtml.HandlerPanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatal(err)
+	}
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Errorf("got value %v and stack %q", panicErr.Value, panicErr.Stack)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times after panicking; want 1", calls)
+	}
+	if err = w.Close(); !errors.Is(err, panicErr) {
+		t.Error(err)
+	}
+}
+
+func TestWriter_UseAfterClose(t *testing.T) {
+	w, err := lolhtml.NewWriter(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = w.Write([]byte("<div>")); err != lolhtml.ErrWriterClosed {
+		t.Errorf("got %v; want ErrWriterClosed", err)
+	}
+	if _, err = w.WriteString("<div>"); err != lolhtml.ErrWriterClosed {
+		t.Errorf("got %v; want ErrWriterClosed", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriter_UseAfterError(t *testing.T) {
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						return lolhtml.Stop
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = w.Write([]byte("<div>")); !errors.Is(err, lolhtml.ErrStopped) {
+			t.Errorf("got %v; want ErrStopped", err)
+		}
+	}
+	if err = w.Close(); !errors.Is(err, lolhtml.ErrStopped) {
+		t.Errorf("got %v; want ErrStopped", err)
+	}
+}
+
+func TestWriter_ReentrantCall(t *testing.T) {
+	var w *lolhtml.Writer
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "div",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if _, err := w.Write([]byte("<span>")); err != lolhtml.ErrReentrantCall {
+							t.Errorf("got %v; want ErrReentrantCall", err)
+						}
+						if err := w.Close(); err != lolhtml.ErrReentrantCall {
+							t.Errorf("got %v; want ErrReentrantCall", err)
+						}
+						if err := w.Reset(nil); err != lolhtml.ErrReentrantCall {
+							t.Errorf("got %v; want ErrReentrantCall", err)
+						}
+						return lolhtml.Continue
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<div></div>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// flushRecorder records the content written to it at each flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushed []string
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushed = append(f.flushed, f.String())
+}
+
+func TestWriter_Flush(t *testing.T) {
+	var dst flushRecorder
+	w, err := lolhtml.NewWriter(&dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<p>Hi</p>")); err != nil {
+		t.Error(err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if len(dst.flushed) != 1 || dst.flushed[0] != "<p>Hi</p>" {
+		t.Errorf("got flushes %q", dst.flushed)
+	}
+	if err = w.Flush(); err != lolhtml.ErrWriterClosed {
+		t.Errorf("got %v; want ErrWriterClosed", err)
+	}
+}
+
+func TestWriter_AutoFlush(t *testing.T) {
+	newConfig := func(policy *lolhtml.FlushPolicy) lolhtml.Config {
+		return lolhtml.Config{
+			Encoding: "utf-8",
+			Memory: &lolhtml.MemorySettings{
+				PreallocatedParsingBufferSize: 1024,
+				MaxAllowedMemoryUsage:         1<<63 - 1,
+			},
+			Strict:    true,
+			AutoFlush: policy,
+		}
+	}
+	testCases := []struct {
+		policy  *lolhtml.FlushPolicy
+		flushed []string
+	}{
+		{&lolhtml.FlushPolicy{AfterWrite: true}, []string{"<p>1</p>", "<p>1</p><p>2</p>"}},
+		{&lolhtml.FlushPolicy{AfterBytes: 100}, []string{"<p>1</p><p>2</p>"}},
+	}
+	for _, tc := range testCases {
+		var dst flushRecorder
+		w, err := lolhtml.NewWriter(&dst, nil, newConfig(tc.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range []string{"<p>1</p>", "<p>2</p>"} {
+			if _, err = w.WriteString(chunk); err != nil {
+				t.Error(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+		if len(dst.flushed) != len(tc.flushed) {
+			t.Errorf("got flushes %q; want %q", dst.flushed, tc.flushed)
+			continue
+		}
+		for i := range tc.flushed {
+			if dst.flushed[i] != tc.flushed[i] {
+				t.Errorf("got flushes %q; want %q", dst.flushed, tc.flushed)
+			}
+		}
+	}
+}
+
+func TestWriter_ErrorOffset(t *testing.T) {
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			ElementContentHandler: []lolhtml.ElementContentHandler{
+				{
+					Selector: "span",
+					ElementHandler: func(e *lolhtml.Element) lolhtml.RewriterDirective {
+						if loc := e.SourceLocation(); loc != (lolhtml.SourceLocation{Start: 21, End: 27}) {
+							t.Errorf("got %+v", loc)
+						}
+						return lolhtml.Stop
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<div>Hi</div>")); err != nil {
+		t.Error(err)
+	}
+	_, err = w.Write([]byte("<p>1</p><span>2</span>"))
+	var writeErr *lolhtml.WriteError
+	if !errors.As(err, &writeErr) || !errors.Is(err, lolhtml.ErrStopped) {
+		t.Fatal(err)
+	}
+	if writeErr.Offset != 21 {
+		t.Errorf("got offset %d; want 21", writeErr.Offset)
+	}
+	if err.Error() != "The rewriter has been stopped." {
+		t.Error(err)
+	}
+	_ = w.Close()
+}
+
+func TestWriter_CloseErrorOffset(t *testing.T) {
+	w, err := lolhtml.NewWriter(
+		nil,
+		&lolhtml.Handlers{
+			DocumentContentHandler: []lolhtml.DocumentContentHandler{
+				{
+					DocumentEndHandler: func(d *lolhtml.DocumentEnd) lolhtml.RewriterDirective {
+						return lolhtml.Stop
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("<p>1</p><p>2</p>")); err != nil {
+		t.Error(err)
+	}
+	err = w.Close()
+	var writeErr *lolhtml.WriteError
+	if !errors.As(err, &writeErr) || !errors.Is(err, lolhtml.ErrStopped) {
+		t.Fatal(err)
+	}
+	if writeErr.Offset != 16 {
+		t.Errorf("got offset %d; want 16", writeErr.Offset)
+	}
+}